@@ -6,18 +6,27 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	ltiHandler "github.com/quipper/poc/lti/be/internal/controller/http/lti"
+	contextsSqlite "github.com/quipper/poc/lti/be/internal/repositories/contexts/sqlite"
+	keysFile "github.com/quipper/poc/lti/be/internal/repositories/keys/file"
+	keysSqlite "github.com/quipper/poc/lti/be/internal/repositories/keys/sqlite"
+	sqliteRepo "github.com/quipper/poc/lti/be/internal/repositories/lti/sqlite"
 	rosterSqlite "github.com/quipper/poc/lti/be/internal/repositories/roster/sqlite"
 	scoresSqliteRepo "github.com/quipper/poc/lti/be/internal/repositories/scores/sqlite"
-	sqliteRepo "github.com/quipper/poc/lti/be/internal/repositories/lti/sqlite"
+	usersSqlite "github.com/quipper/poc/lti/be/internal/repositories/users/sqlite"
 	vsqliteRepo "github.com/quipper/poc/lti/be/internal/repositories/validation"
 	"github.com/quipper/poc/lti/be/pkg/common/keys"
 	"github.com/quipper/poc/lti/be/pkg/common/logger"
+	keysRepo "github.com/quipper/poc/lti/be/pkg/repositories/keys"
+	ltiIface "github.com/quipper/poc/lti/be/pkg/repositories/lti"
+	scoresIface "github.com/quipper/poc/lti/be/pkg/repositories/scores"
+	validationIface "github.com/quipper/poc/lti/be/pkg/repositories/validation"
 )
 
 func withCORS(next http.Handler) http.Handler {
@@ -33,6 +42,40 @@ func withCORS(next http.Handler) http.Handler {
 	})
 }
 
+// migrateToolScopes sets the allowed scopes of tools registered before scopes were restricted
+// to the service scopes they used: those of their recorded access tokens, and score if they
+// ever posted one. A tool with no recorded use gets none; an admin grants them explicitly.
+func migrateToolScopes(ctx context.Context, tools ltiIface.Repository, validation validationIface.Repository, scores scoresIface.Repository) error {
+	pending, err := tools.ListToolsWithoutAllowedScopes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, t := range pending {
+		used, err := validation.ListClientTokenScopes(ctx, t.ClientID)
+		if err != nil {
+			return err
+		}
+		posted, err := scores.HasClientScores(ctx, t.ClientID)
+		if err != nil {
+			return err
+		}
+		if posted {
+			used = append(used, ltiIface.ScopeScore)
+		}
+		allowed := []string{}
+		for _, s := range ltiIface.ServiceScopes {
+			if slices.Contains(used, s) {
+				allowed = append(allowed, s)
+			}
+		}
+		if _, err := tools.UpdateToolAllowedScopes(ctx, t.ID, allowed); err != nil {
+			return err
+		}
+		logger.Info("tool %d (%s): allowed scopes set to %v from recorded use", t.ID, t.ClientID, allowed)
+	}
+	return nil
+}
+
 func main() {
 	level := os.Getenv("LOG_LEVEL")
 	if level == "" {
@@ -41,12 +84,59 @@ func main() {
 	logger.Initialize(level)
 	logger.Info("starting server")
 
+	// Platform key store: keys are created on first boot and reloaded on restart.
+	// PLATFORM_KEYSTORE=sqlite (default) | file | none (env vars or ephemeral dev key)
+	var keyStore keysRepo.KeyStore
+	switch ks := os.Getenv("PLATFORM_KEYSTORE"); ks {
+	case "", "sqlite":
+		kdbPath := os.Getenv("KEYS_SQLITE_PATH")
+		if kdbPath == "" {
+			kdbPath = "./keys.db"
+		}
+		s, err := keysSqlite.NewSQLiteRepo(kdbPath)
+		if err != nil {
+			logger.Error("init key store: %v", err)
+			os.Exit(1)
+		}
+		keyStore = s
+	case "file":
+		kfPath := os.Getenv("PLATFORM_KEYSTORE_FILE")
+		if kfPath == "" {
+			kfPath = "./platform_keys.enc"
+		}
+		s, err := keysFile.NewEncryptedFileRepo(kfPath, os.Getenv("PLATFORM_KEYSTORE_PASSPHRASE"))
+		if err != nil {
+			logger.Error("init key store: %v", err)
+			os.Exit(1)
+		}
+		keyStore = s
+	case "none":
+	default:
+		logger.Error("unknown PLATFORM_KEYSTORE=%q", ks)
+		os.Exit(1)
+	}
+	if keyStore != nil {
+		keys.SetStore(keyStore)
+	}
+
 	// Initialize signing keys early so that if we generate a dev key,
 	// the PEM export instructions are printed immediately at startup.
 	if err := keys.Init(); err != nil {
 		logger.Error("init keys: %v", err)
 		os.Exit(1)
 	}
+	// Optional scheduled rotation; rotated-out keys stay in the JWKS for PLATFORM_KEY_GRACE_PERIOD.
+	rotationCtx, stopRotation := context.WithCancel(context.Background())
+	defer stopRotation()
+	if v := os.Getenv("PLATFORM_KEY_ROTATION_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil {
+			logger.Error("invalid PLATFORM_KEY_ROTATION_INTERVAL: %v", err)
+			os.Exit(1)
+		}
+		keys.StartRotation(rotationCtx, interval)
+		logger.Info("key rotation enabled: every %s", interval)
+	}
 
 	dbPath := os.Getenv("SQLITE_PATH")
 	if dbPath == "" {
@@ -79,6 +169,11 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := migrateToolScopes(context.Background(), repo, vrepo, scoresRepo); err != nil {
+		logger.Error("migrate tool scopes: %v", err)
+		os.Exit(1)
+	}
+
 	// Roster repository (NRPS sandbox storage)
 	rdbPath := os.Getenv("ROSTER_SQLITE_PATH")
 	if rdbPath == "" {
@@ -90,7 +185,29 @@ func main() {
 		os.Exit(1)
 	}
 
-	h := ltiHandler.NewHandler(repo, scoresRepo, vrepo, rosterRepo)
+	// Users repository (platform identities for launches)
+	udbPath := os.Getenv("USERS_SQLITE_PATH")
+	if udbPath == "" {
+		udbPath = "./users.db"
+	}
+	usersRepo, err := usersSqlite.NewSQLiteRepo(udbPath)
+	if err != nil {
+		logger.Error("init users repo: %v", err)
+		os.Exit(1)
+	}
+
+	// Contexts repository (courses launched into and served by AGS/NRPS)
+	cdbPath := os.Getenv("CONTEXTS_SQLITE_PATH")
+	if cdbPath == "" {
+		cdbPath = "./contexts.db"
+	}
+	contextsRepo, err := contextsSqlite.NewSQLiteRepo(cdbPath)
+	if err != nil {
+		logger.Error("init contexts repo: %v", err)
+		os.Exit(1)
+	}
+
+	h := ltiHandler.NewHandler(repo, scoresRepo, vrepo, rosterRepo, usersRepo, contextsRepo)
 	router := chi.NewRouter()
 	const maxBodySize = 2_100_000
 	router.Use(middleware.RequestSize(maxBodySize))
@@ -118,6 +235,7 @@ func main() {
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 	<-stop
 	logger.Info("shutting down...")
+	stopRotation()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -133,5 +251,14 @@ func main() {
 	if scoresRepo != nil {
 		scoresRepo.Disconnect()
 	}
+	if usersRepo != nil {
+		usersRepo.Disconnect()
+	}
+	if contextsRepo != nil {
+		contextsRepo.Disconnect()
+	}
+	if keyStore != nil {
+		keyStore.Disconnect()
+	}
 	logger.Info("server stopped")
 }
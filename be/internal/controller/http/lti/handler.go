@@ -1,16 +1,22 @@
 package lti
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/quipper/poc/lti/be/pkg/common/jwkscache"
 	"github.com/quipper/poc/lti/be/pkg/common/keys"
+	"github.com/quipper/poc/lti/be/pkg/common/logger"
+	"github.com/quipper/poc/lti/be/pkg/common/ltiroles"
+	contextsRepo "github.com/quipper/poc/lti/be/pkg/repositories/contexts"
 	repoIface "github.com/quipper/poc/lti/be/pkg/repositories/lti"
 	rosterRepo "github.com/quipper/poc/lti/be/pkg/repositories/roster"
 	scoresRepo "github.com/quipper/poc/lti/be/pkg/repositories/scores"
+	usersRepo "github.com/quipper/poc/lti/be/pkg/repositories/users"
 	vRepoIface "github.com/quipper/poc/lti/be/pkg/repositories/validation"
 )
 
@@ -18,28 +24,85 @@ type Handler struct {
 	repo           repoIface.Repository
 	scores         scoresRepo.Repository
 	roster         rosterRepo.Repository
+	users          usersRepo.Repository
+	contexts       contextsRepo.Repository
 	issuer         string
 	jwksCache      jwkscache.Cache
 	validationRepo vRepoIface.Repository
+	// userHeader names a request header carrying the authenticated user's ID or email,
+	// set by an authenticating proxy in front of the platform (PLATFORM_USER_HEADER).
+	userHeader string
+	// roleMapper builds the roles claim from roster and institution roles (LTI_ROLE_MAP, LTI_INSTITUTION_ROLES).
+	roleMapper *ltiroles.Mapper
+	// markInactive lets Inactive roster members launch with custom membership_status=Inactive
+	// instead of being rejected (LTI_INACTIVE_MEMBERS=mark).
+	markInactive bool
+	// strictDeepLinking rejects deep linking responses that are unverified or do not match
+	// the request they answer (LTI_DEEP_LINKING_STRICT=true).
+	strictDeepLinking bool
+	// adminToken is the Bearer token the admin key and token revocation routes require
+	// (ADMIN_API_TOKEN); those routes are disabled when it is empty.
+	adminToken string
 }
 
-// NewHandler constructs a Handler with explicit tools, scores and validation repositories.
+// NewHandler constructs a Handler with explicit tools, scores, validation, roster, users and contexts repositories.
 // Useful when these come from different backends or databases.
-func NewHandler(tools repoIface.Repository, scores scoresRepo.Repository, validation vRepoIface.Repository, roster rosterRepo.Repository) *Handler {
+func NewHandler(tools repoIface.Repository, scores scoresRepo.Repository, validation vRepoIface.Repository, roster rosterRepo.Repository, users usersRepo.Repository, contexts contextsRepo.Repository) *Handler {
 	iss := os.Getenv("PLATFORM_ISSUER")
 	if iss == "" {
 		iss = "https://monarch-legal-admittedly.ngrok-free.app"
 	}
 	return &Handler{
-		repo:           tools,
-		scores:         scores,
-		roster:         roster,
-		issuer:         iss,
-		jwksCache:      jwkscache.Default(),
-		validationRepo: validation,
+		repo:              tools,
+		scores:            scores,
+		roster:            roster,
+		users:             users,
+		contexts:          contexts,
+		issuer:            iss,
+		jwksCache:         jwkscache.Default(),
+		validationRepo:    validation,
+		userHeader:        os.Getenv("PLATFORM_USER_HEADER"),
+		roleMapper:        ltiroles.FromEnv(),
+		markInactive:      strings.EqualFold(os.Getenv("LTI_INACTIVE_MEMBERS"), "mark"),
+		strictDeepLinking: strings.EqualFold(os.Getenv("LTI_DEEP_LINKING_STRICT"), "true"),
+		adminToken:        os.Getenv("ADMIN_API_TOKEN"),
 	}
 }
 
+// publicBaseURL returns the externally reachable base URL of the platform.
+// PUBLIC_BASE_URL overrides the issuer when the platform sits behind a proxy.
+func (h *Handler) publicBaseURL() string {
+	if pub := os.Getenv("PUBLIC_BASE_URL"); pub != "" {
+		return pub
+	}
+	return h.issuer
+}
+
+// isAdmin reports whether r carries the configured admin token as Bearer.
+func (h *Handler) isAdmin(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	return h.adminToken != "" && strings.HasPrefix(strings.ToLower(auth), "bearer ") &&
+		subtle.ConstantTimeCompare([]byte(strings.TrimSpace(auth[len("Bearer "):])), []byte(h.adminToken)) == 1
+}
+
+// requireAdmin responds 401 unless the request carries the admin token as Bearer, and 403 for
+// every request when no admin token is configured.
+func (h *Handler) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.adminToken == "" {
+			http.Error(w, "admin API disabled: ADMIN_API_TOKEN is not set", http.StatusForbidden)
+			return
+		}
+		if !h.isAdmin(r) {
+			logger.Debug("admin auth failed path=%s", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // Router returns a chi-based router for the /api endpoints.
 func (h *Handler) Router() http.Handler {
 	r := chi.NewRouter()
@@ -49,6 +112,23 @@ func (h *Handler) Router() http.Handler {
 	// Platform metadata/JWKS
 	r.Get("/.well-known/jwks.json", h.jwks)
 	r.Get("/api/.well-known/jwks.json", h.jwks)
+	r.Get("/.well-known/openid-configuration", h.openIDConfiguration)
+	r.Get("/api/.well-known/openid-configuration", h.openIDConfiguration)
+
+	// Platform signing key management, tool scopes and token revocation (admin, ADMIN_API_TOKEN)
+	r.Group(func(r chi.Router) {
+		r.Use(h.requireAdmin)
+		r.Get("/api/admin/keys", h.adminListKeys)
+		r.Post("/api/admin/keys", h.adminCreateKey)
+		r.Delete("/api/admin/keys/{kid}", h.adminRetireKey)
+		r.Put("/api/tools/{id}/scopes", h.updateToolScopes)
+		r.Post("/api/tools/{id}/tokens/revoke", h.revokeToolTokens)
+	})
+
+	// LTI Dynamic Registration
+	r.Get("/api/registration/start", h.registrationStart)
+	r.Post("/api/registration/start", h.registrationStart)
+	r.Post("/api/registration", h.registrationCreate)
 
 	// LTI launch (3rd-party initiated login)
 	r.Post("/api/launch/start", h.launchStart)
@@ -59,18 +139,51 @@ func (h *Handler) Router() http.Handler {
 	r.Get("/api/deeplink/return", h.deeplinkReturn)
 	r.Post("/api/deeplink/return", h.deeplinkReturn)
 	r.Post("/api/oauth2/token", h.oauth2Token)
+	r.Post("/api/oauth2/introspect", h.oauth2Introspect)
+	r.Post("/api/oauth2/revoke", h.oauth2Revoke)
 	r.Get("/api/tools", h.listTools)
 	r.Get("/api/tools/{id}", h.getToolByIDChi)
 	r.Post("/api/tools", h.createTool)
 	r.Delete("/api/tools/{id}", h.deleteToolChi)
+	r.Get("/api/tools/{id}/deployments", h.listDeployments)
+	r.Post("/api/tools/{id}/deployments", h.createDeployment)
+	r.Get("/api/tools/{id}/deployments/{deploymentId}", h.getDeployment)
+	r.Delete("/api/tools/{id}/deployments/{deploymentId}", h.deleteDeployment)
+
+	// Platform users (admin)
+	r.Get("/api/users", h.listUsers)
+	r.Post("/api/users", h.createUser)
+	r.Get("/api/users/{id}", h.getUser)
+	r.Put("/api/users/{id}", h.updateUser)
+	r.Delete("/api/users/{id}", h.deleteUser)
+
+	// Platform contexts / courses (admin)
+	r.Get("/api/contexts", h.listContexts)
+	r.Post("/api/contexts", h.createContext)
+	r.Get("/api/contexts/{id}", h.getContext)
+	r.Put("/api/contexts/{id}", h.updateContext)
+	r.Delete("/api/contexts/{id}", h.deleteContext)
+	r.Get("/api/contexts/{id}/lineitems/{lineItemId}/scores/{userId}", h.scoreHistory)
+
+	// Resource links (admin)
+	r.Get("/api/resource-links", h.listResourceLinks)
+	r.Post("/api/resource-links", h.createResourceLink)
+	r.Get("/api/resource-links/{id}", h.getResourceLink)
+	r.Put("/api/resource-links/{id}", h.updateResourceLink)
+	r.Delete("/api/resource-links/{id}", h.deleteResourceLink)
 
 	// Deep link selections CRUD (list/get/delete)
 	r.Get("/api/deeplink/selections", h.listSelections)
 	r.Get("/api/deeplink/selections/{id}", h.getSelectionByID)
 	r.Delete("/api/deeplink/selections/{id}", h.deleteSelectionByID)
 
+	// Deep linking sessions (read-only)
+	r.Get("/api/deeplink/sessions", h.listDeepLinkSessions)
+	r.Get("/api/deeplink/sessions/{id}", h.getDeepLinkSession)
+
 	// AGS endpoints (context-scoped)
 	r.Route("/api/ags/contexts/{contextId}", func(r chi.Router) {
+		r.Use(h.requireKnownContext)
 		// Line items
 		r.With(h.agsRequireScopes("https://purl.imsglobal.org/spec/lti-ags/scope/lineitem.readonly", "https://purl.imsglobal.org/spec/lti-ags/scope/lineitem")).Get("/lineitems", h.agsListLineItems)
 		r.With(h.agsRequireScopes("https://purl.imsglobal.org/spec/lti-ags/scope/lineitem")).Post("/lineitems", h.agsCreateLineItem)
@@ -80,10 +193,12 @@ func (h *Handler) Router() http.Handler {
 		// Scores and Results
 		r.With(h.agsRequireScopes("https://purl.imsglobal.org/spec/lti-ags/scope/score")).Post("/lineitems/{lineItemId}/scores", h.agsPostScore)
 		r.With(h.agsRequireScopes("https://purl.imsglobal.org/spec/lti-ags/scope/result.readonly")).Get("/lineitems/{lineItemId}/results", h.agsListResults)
+		r.With(h.agsRequireScopes("https://purl.imsglobal.org/spec/lti-ags/scope/result.readonly")).Get("/lineitems/{lineItemId}/results/{resultId}", h.agsGetResult)
 	})
 
 	// NRPS endpoints (context-scoped)
 	r.Route("/api/nrps/contexts/{contextId}", func(r chi.Router) {
+		r.Use(h.requireKnownContext)
 		// Memberships list (readonly scope per spec)
 		r.With(h.nrpsRequireScopes("https://purl.imsglobal.org/spec/lti-nrps/scope/contextmembership.readonly")).Get("/members", h.nrpsListMembers)
 		// Sandbox helpers to manage a local roster (no official write scope in spec; keep unscoped or protect with same scope)
@@ -120,5 +235,7 @@ func (h *Handler) jwks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	// Keep tool-side caching short relative to the key grace period so rotations are picked up.
+	w.Header().Set("Cache-Control", "public, max-age=300")
 	_, _ = w.Write(data)
 }
@@ -27,6 +27,7 @@ type apiLineItem struct {
 	ScoreMaximum   float64    `json:"scoreMaximum"`
 	StartAt        *time.Time `json:"startDateTime,omitempty"`
 	EndAt          *time.Time `json:"endDateTime,omitempty"`
+	GradesReleased *bool      `json:"gradesReleased,omitempty"`
 }
 
 func buildBaseURL(r *http.Request) string {
@@ -56,9 +57,42 @@ func toAPI(r *http.Request, li *scoresRepo.LineItem) apiLineItem {
 		ScoreMaximum:   li.ScoreMaximum,
 		StartAt:        li.StartAt,
 		EndAt:          li.EndAt,
+		GradesReleased: li.GradesReleased,
 	}
 }
 
+// apiResult is the public shape of a result per AGS spec, with id and scoreOf as URLs.
+type apiResult struct {
+	ID               string    `json:"id"`
+	ScoreOf          string    `json:"scoreOf"`
+	UserID           string    `json:"userId"`
+	ResultScore      *float64  `json:"resultScore,omitempty"`
+	ResultMaximum    *float64  `json:"resultMaximum,omitempty"`
+	Comment          string    `json:"comment,omitempty"`
+	Timestamp        time.Time `json:"timestamp"`
+	ActivityProgress string    `json:"activityProgress,omitempty"`
+	GradingProgress  string    `json:"gradingProgress,omitempty"`
+}
+
+// resultToAPI maps res to the API shape; its score is only returned once it is final
+// (gradingProgress FullyGraded).
+func resultToAPI(r *http.Request, contextID string, res *scoresRepo.Result) apiResult {
+	lineItem := itemURL(r, contextID, res.LineItemID)
+	out := apiResult{
+		ID:               lineItem + "/results/" + strconv.FormatInt(res.ID, 10),
+		ScoreOf:          lineItem,
+		UserID:           res.UserID,
+		Comment:          res.Comment,
+		Timestamp:        res.Timestamp,
+		ActivityProgress: res.ActivityProgress,
+		GradingProgress:  res.GradingProgress,
+	}
+	if resultFinal(res) {
+		out.ResultScore, out.ResultMaximum = res.ResultScore, res.ResultMaximum
+	}
+	return out
+}
+
 // agsListLineItems GET /api/ags/contexts/{contextId}/lineitems
 func (h *Handler) agsListLineItems(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -71,30 +105,50 @@ func (h *Handler) agsListLineItems(w http.ResponseWriter, r *http.Request) {
 		}
 		r.Body = io.NopCloser(bytes.NewReader(nil))
 	}
-	logger.Debug("AGS list lineitems: context_id=%s", contextID)
-	items, err := h.scores.ListLineItems(ctx, contextID)
+	// Filters per AGS spec, plus pagination params
+	q := r.URL.Query()
+	filter := scoresRepo.LineItemFilter{
+		ResourceLinkID: q.Get("resource_link_id"),
+		ResourceID:     q.Get("resource_id"),
+		Tag:            q.Get("tag"),
+	}
+	offset, limit := pageParams(r)
+	logger.Debug("AGS list lineitems: context_id=%s filter=%+v offset=%d limit=%d", contextID, filter, offset, limit)
+	// Only line items the calling tool created or is linked to
+	tool := agsTool(r)
+	if tool == nil {
+		http.Error(w, "unknown tool", http.StatusForbidden)
+		return
+	}
+	filter.OwnerClientID = tool.ClientID
+	links, err := h.repo.ListResourceLinks(ctx, contextID)
+	if err != nil {
+		logger.Error("AGS list lineitems: list resource links: %v", err)
+		http.Error(w, "repository error", http.StatusInternalServerError)
+		return
+	}
+	for _, l := range links {
+		if l.ToolID == tool.ID {
+			filter.OwnerResourceLinkIDs = append(filter.OwnerResourceLinkIDs, l.ID)
+		}
+	}
+	// DB-level filtering and pagination
+	items, total, err := h.scores.ListLineItemsPage(ctx, contextID, filter, offset, limit)
 	if err != nil {
 		logger.Debug("AGS list lineitems error: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	// Optional filtering by resource_link_id as per AGS spec
-	q := r.URL.Query()
-	if rl := q.Get("resource_link_id"); rl != "" {
-		filtered := make([]*scoresRepo.LineItem, 0, len(items))
-		for i := range items {
-			if items[i].ResourceLinkID == rl {
-				filtered = append(filtered, items[i])
-			}
-		}
-		items = filtered
+	// Set Link: rel="next" if more pages
+	if offset+limit < total {
+		w.Header().Add("Link", "<"+buildPageURL(r, offset+limit, limit)+">; rel=\"next\"")
 	}
 	// Map to API shape with URL ids
 	resp := make([]apiLineItem, 0, len(items))
 	for i := range items {
 		resp = append(resp, toAPI(r, items[i]))
 	}
-	logger.Debug("AGS list lineitems ok: count=%d", len(resp))
+	logger.Debug("AGS list lineitems ok: returned=%d total=%d has_next=%v", len(resp), total, offset+limit < total)
 	if b, err := json.Marshal(resp); err == nil {
 		logger.Debug("AGS list lineitems response: %s", string(b))
 	}
@@ -126,6 +180,22 @@ func (h *Handler) agsCreateLineItem(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "scoreMaximumIsRequired", http.StatusBadRequest)
 		return
 	}
+	// The calling tool owns the line item and may only link it to its own resource links.
+	tool := agsTool(r)
+	li.ClientID = tool.ClientID
+	if li.ResourceLinkID != "" {
+		ok, err := h.agsResourceLinkOwned(ctx, tool, contextID, li.ResourceLinkID)
+		if err != nil {
+			logger.Error("AGS create lineitem resource link check: %v", err)
+			http.Error(w, "repository error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			logger.Debug("AGS create lineitem validation failed: resourceLinkId=%s is not a link of client=%s", li.ResourceLinkID, tool.ClientID)
+			http.Error(w, "invalidResourceLinkId", http.StatusBadRequest)
+			return
+		}
+	}
 	id, err := h.scores.CreateLineItem(ctx, &li)
 	if err != nil {
 		logger.Debug("AGS create lineitem repo error: %v", err)
@@ -147,7 +217,6 @@ func (h *Handler) agsCreateLineItem(w http.ResponseWriter, r *http.Request) {
 
 // agsGetLineItem GET /api/ags/contexts/{contextId}/lineitems/{lineItemId}
 func (h *Handler) agsGetLineItem(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
 	contextID := chi.URLParam(r, "contextId")
 	idStr := chi.URLParam(r, "lineItemId")
 	// Log raw request details
@@ -165,15 +234,8 @@ func (h *Handler) agsGetLineItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	logger.Debug("AGS get lineitem: context_id=%s id=%d", contextID, id)
-	li, err := h.scores.GetLineItem(ctx, id, contextID)
-	if err != nil {
-		logger.Debug("AGS get lineitem repo error: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	li := h.agsAccessibleLineItem(w, r, contextID, id)
 	if li == nil {
-		logger.Debug("AGS get lineitem not found: id=%d", id)
-		http.NotFound(w, r)
 		return
 	}
 	logger.Debug("AGS get lineitem ok: id=%d", id)
@@ -210,8 +272,26 @@ func (h *Handler) agsUpdateLineItem(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalidJson", http.StatusBadRequest)
 		return
 	}
+	existing := h.agsAccessibleLineItem(w, r, contextID, id)
+	if existing == nil {
+		return
+	}
 	li.ID = id
 	li.ContextID = contextID
+	li.ClientID = existing.ClientID
+	if li.ResourceLinkID != "" && li.ResourceLinkID != existing.ResourceLinkID {
+		ok, err := h.agsResourceLinkOwned(ctx, agsTool(r), contextID, li.ResourceLinkID)
+		if err != nil {
+			logger.Error("AGS update lineitem resource link check: %v", err)
+			http.Error(w, "repository error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			logger.Debug("AGS update lineitem validation failed: resourceLinkId=%s is not a link of the calling tool", li.ResourceLinkID)
+			http.Error(w, "invalidResourceLinkId", http.StatusBadRequest)
+			return
+		}
+	}
 
 	if err := h.scores.UpdateLineItem(ctx, &li); err != nil {
 		logger.Debug("AGS update lineitem repo error: %v", err)
@@ -243,6 +323,9 @@ func (h *Handler) agsDeleteLineItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	logger.Debug("AGS delete lineitem: context_id=%s id=%d", contextID, id)
+	if h.agsAccessibleLineItem(w, r, contextID, id) == nil {
+		return
+	}
 	if err := h.scores.DeleteLineItem(ctx, id, contextID); err != nil {
 		logger.Debug("AGS delete lineitem repo error: %v", err)
 		if errors.Is(err, sql.ErrNoRows) {
@@ -275,16 +358,42 @@ func (h *Handler) agsPostScore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	logger.Debug("AGS post score: context_id=%s id=%d", contextID, id)
+	if h.agsAccessibleLineItem(w, r, contextID, id) == nil {
+		return
+	}
+	if !scoreMediaTypeOK(r) {
+		logger.Debug("AGS post score unsupported content type: %s", r.Header.Get("Content-Type"))
+		http.Error(w, "unsupportedMediaType", http.StatusUnsupportedMediaType)
+		return
+	}
 	var s scoresRepo.Score
 	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
 		logger.Debug("AGS post score decode error: %v", err)
+		var pe *time.ParseError
+		if errors.As(err, &pe) {
+			http.Error(w, "invalidTimestamp", http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "invalidJson", http.StatusBadRequest)
 		return
 	}
-	if s.Timestamp.IsZero() {
-		s.Timestamp = time.Now().UTC()
+	if code := scoreProblem(&s); code != "" {
+		logger.Debug("AGS post score invalid: %s", code)
+		http.Error(w, code, http.StatusBadRequest)
+		return
 	}
-	if err := h.scores.UpsertResultFromScore(ctx, id, contextID, &s); err != nil {
+	if err := h.scores.RecordScore(ctx, id, contextID, agsTool(r).ClientID, &s); err != nil {
+		// Per AGS spec, scores older than the current result are ignored and equal timestamps rejected.
+		if errors.Is(err, scoresRepo.ErrStaleScore) {
+			logger.Debug("AGS post score stale: user=%s timestamp=%s", s.UserID, s.Timestamp.Format(time.RFC3339Nano))
+			http.Error(w, "staleScoreTimestamp", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, scoresRepo.ErrDuplicateScoreTimestamp) {
+			logger.Debug("AGS post score duplicate timestamp: user=%s timestamp=%s", s.UserID, s.Timestamp.Format(time.RFC3339Nano))
+			http.Error(w, "duplicateScoreTimestamp", http.StatusBadRequest)
+			return
+		}
 		logger.Debug("AGS post score repo error: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -320,16 +429,67 @@ func (h *Handler) agsListResults(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	logger.Debug("AGS list results: context_id=%s id=%d", contextID, id)
-	results, err := h.scores.ListResultsByLineItem(ctx, id, contextID)
+	if h.agsAccessibleLineItem(w, r, contextID, id) == nil {
+		return
+	}
+	// Optional user_id filter per AGS spec, plus pagination params
+	userID := r.URL.Query().Get("user_id")
+	offset, limit := pageParams(r)
+	logger.Debug("AGS list results: user_id=%s offset=%d limit=%d", userID, offset, limit)
+	results, total, err := h.scores.ListResultsPage(ctx, id, contextID, userID, offset, limit)
 	if err != nil {
 		logger.Debug("AGS list results repo error: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	logger.Debug("AGS list results ok: count=%d", len(results))
-	if b, err := json.Marshal(results); err == nil {
+	// Set Link: rel="next" if more pages
+	if offset+limit < total {
+		w.Header().Add("Link", "<"+buildPageURL(r, offset+limit, limit)+">; rel=\"next\"")
+	}
+	resp := make([]apiResult, 0, len(results))
+	for i := range results {
+		resp = append(resp, resultToAPI(r, contextID, results[i]))
+	}
+	logger.Debug("AGS list results ok: returned=%d total=%d has_next=%v", len(resp), total, offset+limit < total)
+	if b, err := json.Marshal(resp); err == nil {
 		logger.Debug("AGS list results response: %s", string(b))
 	}
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(results)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// agsGetResult GET /api/ags/contexts/{contextId}/lineitems/{lineItemId}/results/{resultId}
+// Dereferences the id URL of a result.
+func (h *Handler) agsGetResult(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	contextID := chi.URLParam(r, "contextId")
+	id, err := strconv.ParseInt(chi.URLParam(r, "lineItemId"), 10, 64)
+	if err != nil {
+		logger.Debug("AGS get result parse id error: %v", err)
+		http.Error(w, "invalid lineItemId", http.StatusBadRequest)
+		return
+	}
+	resultID, err := strconv.ParseInt(chi.URLParam(r, "resultId"), 10, 64)
+	if err != nil {
+		logger.Debug("AGS get result parse result id error: %v", err)
+		http.Error(w, "invalid resultId", http.StatusBadRequest)
+		return
+	}
+	logger.Debug("AGS get result: context_id=%s id=%d result_id=%d", contextID, id, resultID)
+	if h.agsAccessibleLineItem(w, r, contextID, id) == nil {
+		return
+	}
+	res, err := h.scores.GetResult(ctx, resultID, id, contextID)
+	if err != nil {
+		logger.Debug("AGS get result repo error: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if res == nil {
+		logger.Debug("AGS result not found: line_item_id=%d result_id=%d", id, resultID)
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resultToAPI(r, contextID, res))
 }
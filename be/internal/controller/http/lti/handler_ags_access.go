@@ -0,0 +1,76 @@
+package lti
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/quipper/poc/lti/be/pkg/common/logger"
+	repoIface "github.com/quipper/poc/lti/be/pkg/repositories/lti"
+	scoresRepo "github.com/quipper/poc/lti/be/pkg/repositories/scores"
+)
+
+type agsToolKey struct{}
+
+func withAGSTool(ctx context.Context, tool *repoIface.Tool) context.Context {
+	return context.WithValue(ctx, agsToolKey{}, tool)
+}
+
+// agsTool returns the tool whose access token authorized the AGS request.
+func agsTool(r *http.Request) *repoIface.Tool {
+	tool, _ := r.Context().Value(agsToolKey{}).(*repoIface.Tool)
+	return tool
+}
+
+// agsResourceLinkOwned reports whether resourceLinkID is a resource link of tool in contextID.
+func (h *Handler) agsResourceLinkOwned(ctx context.Context, tool *repoIface.Tool, contextID, resourceLinkID string) (bool, error) {
+	l, err := h.repo.GetResourceLink(ctx, resourceLinkID)
+	if err != nil || l == nil {
+		return false, err
+	}
+	return l.ToolID == tool.ID && l.ContextID == contextID, nil
+}
+
+// agsLineItemAccessible reports whether tool may use li: it created the line item, or the
+// line item is linked to one of the tool's resource links.
+func (h *Handler) agsLineItemAccessible(ctx context.Context, tool *repoIface.Tool, li *scoresRepo.LineItem) (bool, error) {
+	if tool == nil {
+		return false, nil
+	}
+	if li.ClientID != "" && li.ClientID == tool.ClientID {
+		return true, nil
+	}
+	if li.ResourceLinkID == "" {
+		return false, nil
+	}
+	return h.agsResourceLinkOwned(ctx, tool, li.ContextID, li.ResourceLinkID)
+}
+
+// agsAccessibleLineItem loads line item id in contextID for the calling tool, writing
+// 404/403/500 and returning nil when it does not exist or the tool may not use it.
+func (h *Handler) agsAccessibleLineItem(w http.ResponseWriter, r *http.Request, contextID string, id int64) *scoresRepo.LineItem {
+	ctx := r.Context()
+	li, err := h.scores.GetLineItem(ctx, id, contextID)
+	if err != nil {
+		logger.Error("AGS get lineitem %d: %v", id, err)
+		http.Error(w, "repository error", http.StatusInternalServerError)
+		return nil
+	}
+	if li == nil {
+		logger.Debug("AGS lineitem not found: context_id=%s id=%d", contextID, id)
+		http.NotFound(w, r)
+		return nil
+	}
+	tool := agsTool(r)
+	ok, err := h.agsLineItemAccessible(ctx, tool, li)
+	if err != nil {
+		logger.Error("AGS lineitem %d access check: %v", id, err)
+		http.Error(w, "repository error", http.StatusInternalServerError)
+		return nil
+	}
+	if !ok {
+		logger.Debug("AGS lineitem %d is not accessible to the calling tool", id)
+		http.Error(w, "line item does not belong to this tool", http.StatusForbidden)
+		return nil
+	}
+	return li
+}
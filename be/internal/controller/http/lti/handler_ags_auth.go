@@ -4,7 +4,7 @@ import (
 	"net/http"
 	"strings"
 
-	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/go-chi/chi/v5"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 	"github.com/quipper/poc/lti/be/pkg/common/keys"
 	"github.com/quipper/poc/lti/be/pkg/common/logger"
@@ -12,6 +12,7 @@ import (
 
 // agsRequireScopes validates the Bearer token and enforces one of the required scopes.
 // It verifies signature, exp, aud and checks the `scope` claim contains at least one required scope.
+// The calling tool is passed on in the request context (see agsTool).
 func (h *Handler) agsRequireScopes(required ...string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -32,16 +33,17 @@ func (h *Handler) agsRequireScopes(required ...string) func(http.Handler) http.H
 			}
 			tokStr := strings.TrimSpace(auth[len("Bearer "):])
 
-			if err := keys.Init(); err != nil {
+			// Validate token against every key still published in the platform key ring,
+			// so tokens signed before a rotation remain valid until they expire.
+			set, err := keys.PublicKeySet()
+			if err != nil {
 				logger.Debug("AGS auth: keys init error: %v", err)
 				http.Error(w, "server keys not initialized", http.StatusInternalServerError)
 				return
 			}
-			// Validate token
-			pub := &keys.PrivateKey().PublicKey
 			aud := h.issuer + "/api"
 			tok, err := jwt.ParseString(tokStr,
-				jwt.WithKey(jwa.RS256, pub),
+				jwt.WithKeySet(set),
 				jwt.WithValidate(true),
 				jwt.WithAudience(aud),
 			)
@@ -51,6 +53,19 @@ func (h *Handler) agsRequireScopes(required ...string) func(http.Handler) http.H
 				http.Error(w, "invalid or expired token", http.StatusUnauthorized)
 				return
 			}
+			// Revoked tokens are rejected before they expire.
+			active, err := h.accessTokenActive(r.Context(), tok)
+			if err != nil {
+				logger.Error("AGS auth: token revocation check: %v", err)
+				http.Error(w, "repository error", http.StatusInternalServerError)
+				return
+			}
+			if !active {
+				logger.Debug("AGS auth: token jti=%s is revoked or unknown", tok.JwtID())
+				w.Header().Set("WWW-Authenticate", `Bearer realm="lti-ags", error="invalid_token", error_description="token has been revoked"`)
+				http.Error(w, "invalid or expired token", http.StatusUnauthorized)
+				return
+			}
 			// Scope check (space-delimited per RFC 6749)
 			scopes, _ := tok.Get("scope")
 			scopeStr, _ := scopes.(string)
@@ -77,8 +92,21 @@ func (h *Handler) agsRequireScopes(required ...string) func(http.Handler) http.H
 				http.Error(w, "insufficient_scope", http.StatusForbidden)
 				return
 			}
-			logger.Debug("AGS auth: ok for path=%s", r.URL.Path)
-			next.ServeHTTP(w, r)
+			// The tool must be deployed in the context it calls into.
+			contextID := chi.URLParam(r, "contextId")
+			tool, err := h.serviceTool(r.Context(), tok, contextID)
+			if err != nil {
+				logger.Error("AGS auth: deployment check: %v", err)
+				http.Error(w, "repository error", http.StatusInternalServerError)
+				return
+			}
+			if tool == nil {
+				logger.Debug("AGS auth: client=%s is not deployed in context=%s", tokenClientID(tok), contextID)
+				http.Error(w, "tool is not deployed in this context", http.StatusForbidden)
+				return
+			}
+			logger.Debug("AGS auth: ok for path=%s client=%s", r.URL.Path, tool.ClientID)
+			next.ServeHTTP(w, r.WithContext(withAGSTool(r.Context(), tool)))
 		})
 	}
 }
@@ -0,0 +1,60 @@
+package lti
+
+import (
+	"mime"
+	"net/http"
+
+	scoresRepo "github.com/quipper/poc/lti/be/pkg/repositories/scores"
+)
+
+// scoreMediaType is the Content-Type the AGS spec requires for score POSTs.
+const scoreMediaType = "application/vnd.ims.lis.v1.score+json"
+
+var (
+	activityProgressValues = map[string]bool{
+		scoresRepo.ActivityInitialized: true,
+		scoresRepo.ActivityStarted:     true,
+		scoresRepo.ActivityInProgress:  true,
+		scoresRepo.ActivitySubmitted:   true,
+		scoresRepo.ActivityCompleted:   true,
+	}
+	gradingProgressValues = map[string]bool{
+		scoresRepo.GradingFullyGraded:   true,
+		scoresRepo.GradingPending:       true,
+		scoresRepo.GradingPendingManual: true,
+		scoresRepo.GradingFailed:        true,
+		scoresRepo.GradingNotReady:      true,
+	}
+)
+
+// scoreMediaTypeOK reports whether the request body is declared as an AGS score.
+func scoreMediaTypeOK(r *http.Request) bool {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mt == scoreMediaType
+}
+
+// scoreProblem returns the AGS error code for the first way s breaks the spec, or "" when it is valid.
+func scoreProblem(s *scoresRepo.Score) string {
+	switch {
+	case s.UserID == "":
+		return "invalidUserId"
+	case s.Timestamp.IsZero():
+		return "invalidTimestamp"
+	case !activityProgressValues[s.ActivityProgress]:
+		return "invalidActivityProgress"
+	case !gradingProgressValues[s.GradingProgress]:
+		return "invalidGradingProgress"
+	case s.ScoreMaximum != nil && *s.ScoreMaximum <= 0:
+		return "invalidScoreMaximum"
+	case s.ScoreGiven != nil && s.ScoreMaximum == nil:
+		return "missingScoreMaximum"
+	case s.ScoreGiven != nil && *s.ScoreGiven < 0:
+		return "invalidScoreGiven"
+	}
+	return ""
+}
+
+// resultFinal reports whether res's score is final and may be shown, i.e. fully graded.
+func resultFinal(res *scoresRepo.Result) bool {
+	return res.GradingProgress == scoresRepo.GradingFullyGraded
+}
@@ -0,0 +1,160 @@
+package lti
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/quipper/poc/lti/be/pkg/common/htmlsanitize"
+	repoIface "github.com/quipper/poc/lti/be/pkg/repositories/lti"
+)
+
+// parseContentItem converts a content item from a deep linking response into its typed form.
+// Items without a type are treated as ltiResourceLink, as earlier versions of the platform did.
+// HTML is sanitized; an error describes why the item cannot be used.
+func parseContentItem(m map[string]any) (*repoIface.ContentItem, error) {
+	if m == nil {
+		return nil, fmt.Errorf("content item is not an object")
+	}
+	item := &repoIface.ContentItem{
+		Type:  stringField(m, "type"),
+		Title: stringField(m, "title"),
+		Text:  stringField(m, "text"),
+		URL:   stringField(m, "url"),
+	}
+	if item.Type == "" {
+		item.Type = repoIface.ContentItemLtiResourceLink
+	}
+	if !containsString(repoIface.ContentItemTypes, item.Type) {
+		return nil, fmt.Errorf("unsupported type %q", item.Type)
+	}
+	if item.URL != "" && !absoluteHTTPURL(item.URL) {
+		return nil, fmt.Errorf("url %q is not an absolute http(s) URL", item.URL)
+	}
+	if item.Type != repoIface.ContentItemHTML {
+		var err error
+		if item.Icon, err = contentImage(m["icon"], "icon"); err != nil {
+			return nil, err
+		}
+		if item.Thumbnail, err = contentImage(m["thumbnail"], "thumbnail"); err != nil {
+			return nil, err
+		}
+	}
+
+	switch item.Type {
+	case repoIface.ContentItemLink:
+		if item.URL == "" {
+			return nil, fmt.Errorf("link item requires url")
+		}
+		if e, ok := m["embed"].(map[string]any); ok {
+			if s := htmlsanitize.Sanitize(stringField(e, "html")); s != "" {
+				item.Embed = &repoIface.ContentEmbed{HTML: s}
+			}
+		}
+		item.Window = contentWindow(m["window"])
+		item.Iframe = contentIframe(m["iframe"])
+	case repoIface.ContentItemFile:
+		if item.URL == "" {
+			return nil, fmt.Errorf("file item requires url")
+		}
+		item.MediaType = stringField(m, "mediaType")
+		if s := stringField(m, "expiresAt"); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, fmt.Errorf("expiresAt %q is not an ISO 8601 date-time", s)
+			}
+			item.ExpiresAt = &t
+		}
+	case repoIface.ContentItemHTML:
+		item.URL = ""
+		item.HTML = htmlsanitize.Sanitize(stringField(m, "html"))
+		if strings.TrimSpace(item.HTML) == "" {
+			return nil, fmt.Errorf("html item requires html")
+		}
+	case repoIface.ContentItemImage:
+		if item.URL == "" {
+			return nil, fmt.Errorf("image item requires url")
+		}
+		item.Width = intField(m, "width")
+		item.Height = intField(m, "height")
+	case repoIface.ContentItemLtiResourceLink:
+		item.Window = contentWindow(m["window"])
+		item.Iframe = contentIframe(m["iframe"])
+		item.Custom = customParams(m["custom"])
+		item.Available = itemWindow(m["available"])
+		item.Submission = itemWindow(m["submission"])
+	}
+	return item, nil
+}
+
+// contentImage reads an icon or thumbnail object; a missing object is nil.
+func contentImage(raw any, field string) (*repoIface.ContentImage, error) {
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return nil, nil
+	}
+	img := &repoIface.ContentImage{URL: stringField(m, "url"), Width: intField(m, "width"), Height: intField(m, "height")}
+	if !absoluteHTTPURL(img.URL) {
+		return nil, fmt.Errorf("%s url %q is not an absolute http(s) URL", field, img.URL)
+	}
+	return img, nil
+}
+
+func contentWindow(raw any) *repoIface.ContentWindow {
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return nil
+	}
+	return &repoIface.ContentWindow{
+		TargetName:     stringField(m, "targetName"),
+		Width:          intField(m, "width"),
+		Height:         intField(m, "height"),
+		WindowFeatures: stringField(m, "windowFeatures"),
+	}
+}
+
+func contentIframe(raw any) *repoIface.ContentIframe {
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return nil
+	}
+	f := &repoIface.ContentIframe{Src: stringField(m, "src"), Width: intField(m, "width"), Height: intField(m, "height")}
+	if f.Src != "" && !absoluteHTTPURL(f.Src) {
+		f.Src = ""
+	}
+	return f
+}
+
+// contentItemFromJSON parses a stored raw content item; nil when it is not a usable item.
+func contentItemFromJSON(s string) *repoIface.ContentItem {
+	var m map[string]any
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		return nil
+	}
+	item, err := parseContentItem(m)
+	if err != nil {
+		return nil
+	}
+	return item
+}
+
+func stringField(m map[string]any, key string) string {
+	s, _ := m[key].(string)
+	return strings.TrimSpace(s)
+}
+
+// intField reads a non-negative JSON number as an int; anything else is 0.
+func intField(m map[string]any, key string) int {
+	f, ok := m[key].(float64)
+	if !ok || f < 0 {
+		return 0
+	}
+	return int(f)
+}
+
+func absoluteHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
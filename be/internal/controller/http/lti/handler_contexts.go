@@ -0,0 +1,165 @@
+package lti
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/quipper/poc/lti/be/pkg/common/logger"
+	contextsRepo "github.com/quipper/poc/lti/be/pkg/repositories/contexts"
+)
+
+const (
+	contextClaim      = "https://purl.imsglobal.org/spec/lti/claim/context"
+	contextTypePrefix = "http://purl.imsglobal.org/vocab/lis/v2/course#"
+)
+
+// contextClaimValue builds the LTI context claim for a launch.
+func contextClaimValue(c *contextsRepo.Context) map[string]any {
+	claim := map[string]any{"id": c.ID}
+	if c.Label != "" {
+		claim["label"] = c.Label
+	}
+	if c.Title != "" {
+		claim["title"] = c.Title
+	}
+	if len(c.Types) > 0 {
+		claim["type"] = c.Types
+	}
+	return claim
+}
+
+// normalizeContextTypes expands short LIS context types (e.g. "CourseOffering") into full URIs.
+func normalizeContextTypes(types []string) []string {
+	out := make([]string, 0, len(types))
+	for _, t := range types {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if !strings.Contains(t, "://") {
+			t = contextTypePrefix + t
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// requireKnownContext responds 404 for service routes whose {contextId} is not a known context.
+func (h *Handler) requireKnownContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contextID := chi.URLParam(r, "contextId")
+		c, err := h.contexts.GetContext(r.Context(), contextID)
+		if err != nil {
+			logger.Error("get context %s: %v", contextID, err)
+			http.Error(w, "repository error", http.StatusInternalServerError)
+			return
+		}
+		if c == nil {
+			logger.Debug("unknown context_id=%s path=%s", contextID, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// This is NOT LTI Spec. Admin endpoints to manage contexts (courses).
+// listContexts GET /api/contexts
+func (h *Handler) listContexts(w http.ResponseWriter, r *http.Request) {
+	items, err := h.contexts.ListContexts(r.Context())
+	if err != nil {
+		logger.Error("list contexts: %v", err)
+		http.Error(w, "failed to list contexts", http.StatusInternalServerError)
+		return
+	}
+	if items == nil {
+		items = []*contextsRepo.Context{}
+	}
+	logger.Debug("listContexts: returned %d items", len(items))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(items)
+}
+
+// createContext POST /api/contexts  body: {"id", "label", "title", "type": ["CourseOffering"]}
+func (h *Handler) createContext(w http.ResponseWriter, r *http.Request) {
+	var req contextsRepo.Context
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	req.ID = strings.TrimSpace(req.ID)
+	if req.ID == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+	if existing, err := h.contexts.GetContext(r.Context(), req.ID); err != nil {
+		logger.Error("get context %s: %v", req.ID, err)
+		http.Error(w, "failed to create context", http.StatusInternalServerError)
+		return
+	} else if existing != nil {
+		http.Error(w, "context already exists", http.StatusConflict)
+		return
+	}
+	req.Types = normalizeContextTypes(req.Types)
+	if err := h.contexts.CreateContext(r.Context(), &req); err != nil {
+		logger.Error("create context: %v", err)
+		http.Error(w, "failed to create context", http.StatusInternalServerError)
+		return
+	}
+	logger.Debug("createContext: created id=%s title=%s", req.ID, req.Title)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(req)
+}
+
+// getContext GET /api/contexts/{id}
+func (h *Handler) getContext(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	c, err := h.contexts.GetContext(r.Context(), id)
+	if err != nil {
+		logger.Error("get context %s: %v", id, err)
+		http.Error(w, "failed to get context", http.StatusInternalServerError)
+		return
+	}
+	if c == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(c)
+}
+
+// updateContext PUT /api/contexts/{id}  replaces label, title and type.
+func (h *Handler) updateContext(w http.ResponseWriter, r *http.Request) {
+	var req contextsRepo.Context
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	req.ID = chi.URLParam(r, "id")
+	req.Types = normalizeContextTypes(req.Types)
+	ok, err := h.contexts.UpdateContext(r.Context(), &req)
+	if err != nil {
+		logger.Error("update context %s: %v", req.ID, err)
+		http.Error(w, "failed to update context", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	h.getContext(w, r)
+}
+
+// deleteContext DELETE /api/contexts/{id}
+func (h *Handler) deleteContext(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := h.contexts.DeleteContext(r.Context(), id); err != nil {
+		logger.Error("delete context %s: %v", id, err)
+		http.Error(w, "failed to delete context", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,58 @@
+package lti
+
+import (
+	"context"
+
+	"github.com/quipper/poc/lti/be/pkg/common/ltivars"
+	contextsRepo "github.com/quipper/poc/lti/be/pkg/repositories/contexts"
+	repoIface "github.com/quipper/poc/lti/be/pkg/repositories/lti"
+	usersRepo "github.com/quipper/poc/lti/be/pkg/repositories/users"
+)
+
+// launchCustom merges custom parameters for a launch (tool, then deployment, then resource link,
+// the most specific level winning) and expands substitution variables. The merged parameters are
+// still returned when the deployment lookup fails.
+func (h *Handler) launchCustom(ctx context.Context, tool *repoIface.Tool, deploymentID string, link *repoIface.ResourceLink, vars ltivars.Vars) (map[string]string, error) {
+	var deploymentCustom, linkCustom map[string]string
+	d, err := h.repo.GetDeployment(ctx, tool.ID, deploymentID)
+	if d != nil {
+		deploymentCustom = d.Custom
+	}
+	if link != nil {
+		linkCustom = link.Custom
+	}
+	merged := ltivars.Merge(tool.Custom, deploymentCustom, linkCustom)
+	if len(merged) == 0 {
+		return nil, err
+	}
+	return vars.Expand(merged), err
+}
+
+// launchCustomVars collects the substitution variable values known for a launch.
+// launchContext and link may be nil.
+func launchCustomVars(user *usersRepo.User, launchContext *contextsRepo.Context, link *repoIface.ResourceLink, roles []string) ltivars.Vars {
+	v := ltivars.Vars{}
+	v.Set(ltivars.UserID, user.ID)
+	v.Set(ltivars.UserUsername, user.Email)
+	v.Set(ltivars.PersonNameFull, user.FullName())
+	v.Set(ltivars.PersonNameGiven, user.GivenName)
+	v.Set(ltivars.PersonNameFamily, user.FamilyName)
+	v.Set(ltivars.PersonEmailPrimary, user.Email)
+	v.SetList(ltivars.MembershipRole, roles)
+	if launchContext != nil {
+		v.Set(ltivars.ContextID, launchContext.ID)
+		v.Set(ltivars.ContextLabel, launchContext.Label)
+		v.Set(ltivars.ContextTitle, launchContext.Title)
+		v.SetList(ltivars.ContextType, launchContext.Types)
+	}
+	if link != nil {
+		v.Set(ltivars.ResourceLinkID, link.ID)
+		v.Set(ltivars.ResourceLinkTitle, link.Title)
+		v.Set(ltivars.ResourceLinkDescription, link.Description)
+		v.SetTime(ltivars.ResourceLinkAvailableStart, link.AvailableStart)
+		v.SetTime(ltivars.ResourceLinkAvailableEnd, link.AvailableEnd)
+		v.SetTime(ltivars.ResourceLinkSubmissionStart, link.SubmissionStart)
+		v.SetTime(ltivars.ResourceLinkSubmissionEnd, link.SubmissionEnd)
+	}
+	return v
+}
@@ -1,22 +1,23 @@
 package lti
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
 	"net/http"
-	"strconv"
 	"strings"
 
-	"github.com/lestrrat-go/jwx/v2/jwk"
-	"github.com/lestrrat-go/jwx/v2/jwt"
 	"github.com/quipper/poc/lti/be/pkg/common/logger"
 	repoPkg "github.com/quipper/poc/lti/be/pkg/repositories/lti"
 	scoresRepo "github.com/quipper/poc/lti/be/pkg/repositories/scores"
 )
 
 // deeplinkReturn receives the Tool's Deep Linking Response (JWT via form_post).
-// Verifies the JWT against any registered tool JWKS (PoC approach).
+// Verifies the JWT against any registered tool JWKS (PoC approach); with LTI_DEEP_LINKING_STRICT
+// the response must also be a verified, conformant answer to the request issued in oidcAuth.
 func (h *Handler) deeplinkReturn(w http.ResponseWriter, r *http.Request) {
 	_ = r.ParseForm()
 	// Per 1EdTech Deep Linking spec, the Tool posts the response as form field "JWT".
@@ -35,27 +36,6 @@ func (h *Handler) deeplinkReturn(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ctx := r.Context()
-	// Try to verify against each registered tool's JWKS
-	var matchedTool string
-	tools, _ := h.repo.ListTools(ctx)
-	for _, t := range tools {
-		if t.KeySetURL == "" {
-			continue
-		}
-		set, err := jwk.Fetch(ctx, t.KeySetURL)
-		if err != nil {
-			continue
-		}
-		if _, err := jwt.ParseString(idToken, jwt.WithKeySet(set), jwt.WithValidate(true)); err == nil {
-			matchedTool = t.Name
-			break
-		}
-	}
-	if matchedTool == "" {
-		logger.Debug("DeepLink return: no tool matched JWKS verification")
-	} else {
-		logger.Debug("DeepLink return: verified with tool=%s", matchedTool)
-	}
 
 	// Pretty-print JWT payload by decoding the middle segment
 	var prettyPayload string
@@ -79,18 +59,48 @@ func (h *Handler) deeplinkReturn(w http.ResponseWriter, r *http.Request) {
 	var payload map[string]any
 	if prettyPayload != "{}" {
 		_ = json.Unmarshal([]byte(prettyPayload), &payload)
-	} else {
-		// try decode raw
-		parts := strings.Split(idToken, ".")
-		if len(parts) == 3 {
-			if b, err := base64.RawURLEncoding.DecodeString(parts[1]); err == nil {
-				_ = json.Unmarshal(b, &payload)
-			}
-		}
 	}
 	if payload == nil {
 		payload = map[string]any{}
 	}
+	logger.Debug("DeepLink return: payload=%v", payload)
+
+	// The response must answer an open session of the tool that sent it, named by the signed
+	// data token issued in oidcAuth; replayed, expired and foreign responses are rejected.
+	data, _ := payload[dlDataClaim].(string)
+	iss, _ := payload["iss"].(string)
+	session, err := h.openDeepLinkSession(ctx, data, iss)
+	if err != nil {
+		switch err {
+		case errDeepLinkDataInvalid, errDeepLinkSessionClosed, errDeepLinkSessionExpired, errDeepLinkSessionForeign:
+			logger.Debug("DeepLink return: rejected: %v", err)
+			http.Error(w, "invalid deep linking response: "+err.Error(), http.StatusBadRequest)
+		default:
+			logger.Error("DeepLink return: get deep linking session: %v", err)
+			http.Error(w, "repository error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Verify against the JWKS of the tool the response names as its issuer
+	var matchedTool string
+	verifiedTool := h.verifyDeepLinkResponse(ctx, idToken, iss)
+	if verifiedTool == nil {
+		logger.Debug("DeepLink return: no tool matched JWKS verification")
+	} else {
+		matchedTool = verifiedTool.Name
+		logger.Debug("DeepLink return: verified with tool=%s", matchedTool)
+	}
+
+	// Strict mode rejects anything that is not a verified, spec-conformant answer to our request.
+	if problems := h.deepLinkResponseProblems(payload, session, verifiedTool); len(problems) > 0 {
+		logger.Debug("DeepLink return: response problems: %s", strings.Join(problems, "; "))
+		if h.strictDeepLinking {
+			http.Error(w, "invalid deep linking response: "+strings.Join(problems, "; "), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Extract client_id/aud best-effort
 	clientID := ""
 	if v, ok := payload["aud"]; ok {
@@ -105,91 +115,160 @@ func (h *Handler) deeplinkReturn(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
-	// Extract content_items (IMS claim)
 
-	contextId := ""
-	logger.Debug("DeepLink return: payload=%v", payload)
-	if v, ok := payload["https://purl.imsglobal.org/spec/lti-dl/claim/data"].(string); ok {
-		contextId = v
-		logger.Debug("DeepLink return: contextId=%s", contextId)
+	contextId := session.ContextID
+	logger.Debug("DeepLink return: session=%s contextId=%s", session.ID, contextId)
+
+	// The response must come from a deployment of the verified tool that covers the context.
+	depID, _ := payload[deploymentIDClaim].(string)
+	if verifiedTool != nil {
+		d, err := h.repo.GetDeployment(ctx, verifiedTool.ID, depID)
+		if err != nil {
+			logger.Error("DeepLink return: get deployment: %v", err)
+			http.Error(w, "repository error", http.StatusInternalServerError)
+			return
+		}
+		if d == nil || (contextId != "" && !d.Covers(contextId)) {
+			logger.Debug("DeepLink return: deployment_id=%q is not a deployment of tool=%s for context=%s", depID, matchedTool, contextId)
+			http.Error(w, "deep linking response deployment_id does not match a deployment of this tool", http.StatusBadRequest)
+			return
+		}
 	}
 
-	const dlItemsClaim = "https://purl.imsglobal.org/spec/lti-dl/claim/content_items"
-	if raw, ok := payload[dlItemsClaim]; ok {
+	// Resource links and line items are only created for, and owned by, the verified tool; an
+	// unverified response could claim any tool's iss, so its items are only stored as selections.
+	linkTool, linkDeploymentID := verifiedTool, depID
+
+	// Close the session before persisting anything so a concurrent replay cannot also be accepted.
+	setDeepLinkOutcome(session, payload)
+	if ok, err := h.repo.CloseDeepLinkSession(ctx, session); err != nil {
+		logger.Error("DeepLink return: close session: %v", err)
+		http.Error(w, "repository error", http.StatusInternalServerError)
+		return
+	} else if !ok {
+		logger.Debug("DeepLink return: session=%s is no longer open", session.ID)
+		http.Error(w, "invalid deep linking response: "+errDeepLinkSessionClosed.Error(), http.StatusBadRequest)
+		return
+	}
+	if session.Log != "" {
+		logger.Debug("DeepLink return: tool log: %s", session.Log)
+	}
+	if session.ErrorLog != "" {
+		logger.Error("DeepLink return: tool %s reported error: %s", session.ClientID, session.ErrorLog)
+	}
+
+	itemCount := 0
+	var lineItemFailures []string
+	// Content items that could not be stored are reported back with their index and reason.
+	var skippedItems []string
+	if raw, ok := payload[dlContentItemsClaim]; ok {
 		if arr, ok := raw.([]any); ok {
 			logger.Debug("DeepLink return: content_items found count=%d client_id=%s", len(arr), clientID)
-			for _, it := range arr {
+			for i, it := range arr {
 				m, ok := it.(map[string]any)
 				if !ok {
+					logger.Debug("DeepLink return: skipping content item %d: not an object", i)
+					skippedItems = append(skippedItems, fmt.Sprintf("content item %d: skipped: not a JSON object", i))
 					continue
 				}
 				// logger.Debug("DeepLink return: content_items item: %v", m)
-				// url
-				url := ""
-				if v, ok := m["url"].(string); ok {
-					url = v
+				item, err := parseContentItem(m)
+				if err != nil {
+					logger.Debug("DeepLink return: skipping invalid content item %d: %v", i, err)
+					skippedItems = append(skippedItems, fmt.Sprintf("content item %d: skipped: %v", i, err))
+					continue
 				}
+				url := item.URL
 				// full item json
 				fullJSON := "{}"
 				if b, err := json.Marshal(m); err == nil {
 					fullJSON = string(b)
 				}
+				// Validate a requested line item before anything is stored for this item.
+				var lineItem *scoresRepo.LineItem
+				var lineItemErr error
+				if _, ok := m["lineItem"]; ok {
+					if session.AcceptLineItem {
+						lineItem, lineItemErr = lineItemFromContentItem(m, item, contextId)
+					} else {
+						lineItemErr = errors.New("the request did not accept line items")
+					}
+				}
+				// ltiResourceLink items become resource links the platform can launch; the link is
+				// stored together with the selection below.
+				var link *repoPkg.ResourceLink
+				if item.Type == repoPkg.ContentItemLtiResourceLink && linkTool != nil {
+					link = resourceLinkFromItem(linkTool, linkDeploymentID, contextId, item)
+				}
 				// persist minimal fields + full JSON
-				resourceLinkID, _ := h.repo.CreateDeepLinkSelection(r.Context(), &repoPkg.DeepLinkSelection{
+				sel := &repoPkg.DeepLinkSelection{
 					ClientID:        clientID,
 					ToolName:        matchedTool,
+					Type:            item.Type,
 					URL:             url,
+					Item:            item,
 					ContentItemJSON: fullJSON,
-				})
-				logger.Debug("DeepLink return: persisted selection url=%s", url)
-
-				// If claim carries a lineItem, create a new AGS line item and mapping
-				if liRaw, ok := m["lineItem"].(map[string]any); ok {
-					label := ""
-					if v, ok := liRaw["label"].(string); ok {
-						label = v
-					}
-					var scoreMax float64 = 0
-					if v, ok := liRaw["scoreMaximum"].(float64); ok {
-						scoreMax = v
-					} else if v, ok := liRaw["scoreMaximum"].(json.Number); ok {
-						if f, err := v.Float64(); err == nil {
-							scoreMax = f
-						}
+					SessionID:       session.ID,
+					ContextID:       contextId,
+					DeploymentID:    session.DeploymentID,
+					UserID:          session.UserID,
+				}
+				// The resource link and the selection share a transaction in the tools DB. The line
+				// item lives in the scores DB and is only created once the link is stored, so it is
+				// never mapped to a link that does not exist; a link without its line item is harmless.
+				if err := h.repo.CreateDeepLinkSelectionWithLink(ctx, sel, link); err != nil {
+					logger.Error("DeepLink return: persist selection: %v", err)
+					skippedItems = append(skippedItems, fmt.Sprintf("content item %d: skipped: the selection could not be stored", i))
+				} else {
+					itemCount++
+					if link != nil {
+						logger.Debug("DeepLink return: created resource link id=%s tool=%d context=%s", link.ID, linkTool.ID, contextId)
 					}
-					// resourceLinkId and contextId are expected in custom fields if provided
-					logger.Debug("DeepLink return: trying to create lineitem label=%s scoreMax=%f resourceLinkID=%d contextID=%s", label, scoreMax, resourceLinkID, contextId)
-					if label != "" && scoreMax > 0 && resourceLinkID != 0 && contextId != "" {
-						li := scoresRepo.LineItem{
-							ContextID:      contextId,
-							Label:          label,
-							ResourceLinkID: strconv.FormatInt(resourceLinkID, 10),
-							ScoreMaximum:   scoreMax,
-						}
-						newID, err := h.scores.CreateLineItem(r.Context(), &li)
-						if err != nil {
-							logger.Debug("DeepLink return: create lineitem error: %v", err)
-						} else {
-							if err := h.scores.CreateLineItemMapping(r.Context(), newID, strconv.FormatInt(resourceLinkID, 10)); err != nil {
-								logger.Debug("DeepLink return: create mapping error: %v", err)
-							} else {
-								logger.Debug("DeepLink return: created lineitem id=%d mapped to resourceLinkId=%s", newID, strconv.FormatInt(resourceLinkID, 10))
-							}
-						}
-					} else {
-						logger.Debug("DeepLink return: lineItem present but missing required fields label/scoreMaximum/resource_link_id/context_id; skipping create")
+					logger.Debug("DeepLink return: persisted selection url=%s", url)
+					if lineItem != nil {
+						lineItemErr = h.createDeepLinkLineItem(ctx, lineItem, linkTool, link)
 					}
 				}
+				if lineItemErr != nil {
+					logger.Debug("DeepLink return: content item %d: line item not created: %v", i, lineItemErr)
+					lineItemFailures = append(lineItemFailures, fmt.Sprintf("content item %d: line item not created: %v", i, lineItemErr))
+				}
 			}
 		}
 	}
 
+	session.ItemCount = itemCount
+	if err := h.repo.SetDeepLinkSessionItemCount(ctx, session.ID, itemCount); err != nil {
+		logger.Error("DeepLink return: record session item count: %v", err)
+	}
+	logger.Debug("DeepLink return: closed session id=%s status=%s items=%d", session.ID, session.Status, itemCount)
+
+	var messages string
+	if session.Msg != "" {
+		messages += `
+    <p class="msg">` + template.HTMLEscapeString(session.Msg) + `</p>`
+	}
+	if session.ErrorMsg != "" {
+		messages += `
+    <p class="errormsg">` + template.HTMLEscapeString(session.ErrorMsg) + `</p>`
+	} else if session.Status == repoPkg.DeepLinkSessionFailed {
+		messages += `
+    <p class="errormsg">The tool reported an error.</p>`
+	} else if session.Status == repoPkg.DeepLinkSessionCancelled {
+		messages += `
+    <p class="msg">No content was selected.</p>`
+	}
+	for _, f := range append(skippedItems, lineItemFailures...) {
+		messages += `
+    <p class="errormsg">` + template.HTMLEscapeString(f) + `</p>`
+	}
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	page := `<!DOCTYPE html>
 <html>
   <head><meta charset="utf-8"/><title>Deep Linking Result</title></head>
   <body>
-    <h1>Deep Linking Result</h1>
+    <h1>Deep Linking Result</h1>` + messages + `
     <p>verified_with_tool: ` + template.HTMLEscapeString(matchedTool) + `</p>
     <h2>id_token claims</h2>
     <pre>` + template.HTMLEscapeString(prettyPayload) + `</pre>
@@ -199,3 +278,22 @@ func (h *Handler) deeplinkReturn(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte(page))
 	logger.Debug("DeepLink return: response rendered.")
 }
+
+// createDeepLinkLineItem creates the line item a content item asked for, with its mapping to the
+// item's already stored resource link. The returned error is reported back to the user.
+func (h *Handler) createDeepLinkLineItem(ctx context.Context, li *scoresRepo.LineItem, linkTool *repoPkg.Tool, link *repoPkg.ResourceLink) error {
+	if linkTool == nil {
+		return errors.New("the response signature was not verified")
+	}
+	if link == nil {
+		return errors.New("no resource link was created for the item")
+	}
+	li.ResourceLinkID = link.ID
+	li.ClientID = linkTool.ClientID
+	if _, err := h.scores.CreateLineItemWithMapping(ctx, li); err != nil {
+		logger.Error("DeepLink return: create lineitem: %v", err)
+		return errors.New("the line item could not be stored")
+	}
+	logger.Debug("DeepLink return: created lineitem id=%d mapped to resourceLinkId=%s", li.ID, link.ID)
+	return nil
+}
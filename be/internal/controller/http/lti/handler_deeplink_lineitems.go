@@ -0,0 +1,96 @@
+package lti
+
+import (
+	"fmt"
+	"time"
+
+	repoIface "github.com/quipper/poc/lti/be/pkg/repositories/lti"
+	scoresRepo "github.com/quipper/poc/lti/be/pkg/repositories/scores"
+)
+
+// lineItemFromContentItem builds the AGS line item requested by the lineItem of a deep linking
+// content item m (parsed as item) in contextID. The label defaults to the item title; the dates
+// come from the item's submission window, falling back to its available window.
+// The caller sets ResourceLinkID once the item's resource link exists.
+func lineItemFromContentItem(m map[string]any, item *repoIface.ContentItem, contextID string) (*scoresRepo.LineItem, error) {
+	raw, ok := m["lineItem"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("lineItem is not an object")
+	}
+	if item.Type != repoIface.ContentItemLtiResourceLink {
+		return nil, fmt.Errorf("lineItem is only supported on %s items", repoIface.ContentItemLtiResourceLink)
+	}
+	if contextID == "" {
+		return nil, fmt.Errorf("the deep linking request has no context")
+	}
+	li := &scoresRepo.LineItem{ContextID: contextID}
+	var err error
+	if li.Label, err = optionalString(raw, "label"); err != nil {
+		return nil, err
+	}
+	if li.Label == "" {
+		li.Label = item.Title
+	}
+	if li.Label == "" {
+		return nil, fmt.Errorf("lineItem label is required when the item has no title")
+	}
+	scoreMax, ok := raw["scoreMaximum"].(float64)
+	if !ok || scoreMax <= 0 {
+		return nil, fmt.Errorf("lineItem scoreMaximum must be a number greater than 0")
+	}
+	li.ScoreMaximum = scoreMax
+	if li.ResourceID, err = optionalString(raw, "resourceId"); err != nil {
+		return nil, err
+	}
+	if li.Tag, err = optionalString(raw, "tag"); err != nil {
+		return nil, err
+	}
+	if v, ok := raw["gradesReleased"]; ok {
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("lineItem gradesReleased must be a boolean")
+		}
+		li.GradesReleased = &b
+	}
+
+	dates := map[string]*time.Time{}
+	for _, window := range []string{"available", "submission"} {
+		w, ok := m[window].(map[string]any)
+		if !ok {
+			continue
+		}
+		for _, key := range []string{"startDateTime", "endDateTime"} {
+			s, err := optionalString(w, key)
+			if err != nil {
+				return nil, fmt.Errorf("%s %v", window, err)
+			}
+			if s == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s %q is not an ISO 8601 date-time", window, key, s)
+			}
+			// submission is read last, so it wins over available
+			dates[key] = &t
+		}
+	}
+	li.StartAt, li.EndAt = dates["startDateTime"], dates["endDateTime"]
+	if li.StartAt != nil && li.EndAt != nil && li.EndAt.Before(*li.StartAt) {
+		return nil, fmt.Errorf("line item endDateTime is before its startDateTime")
+	}
+	return li, nil
+}
+
+// optionalString reads a string field that may be absent; any other type is an error.
+func optionalString(m map[string]any, key string) (string, error) {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", key)
+	}
+	return s, nil
+}
@@ -0,0 +1,144 @@
+package lti
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/quipper/poc/lti/be/pkg/common/keys"
+	"github.com/quipper/poc/lti/be/pkg/common/logger"
+	repoIface "github.com/quipper/poc/lti/be/pkg/repositories/lti"
+)
+
+// Messages a tool may return with its deep linking response. msg and errormsg are meant for
+// the user; log and errorlog are for the platform's logs.
+const (
+	dlMsgClaim      = "https://purl.imsglobal.org/spec/lti-dl/claim/msg"
+	dlLogClaim      = "https://purl.imsglobal.org/spec/lti-dl/claim/log"
+	dlErrorMsgClaim = "https://purl.imsglobal.org/spec/lti-dl/claim/errormsg"
+	dlErrorLogClaim = "https://purl.imsglobal.org/spec/lti-dl/claim/errorlog"
+)
+
+// deepLinkSessionTTL bounds how long a user may stay in a tool's content picker.
+const deepLinkSessionTTL = time.Hour
+
+// Reasons a deep linking response does not answer an open session of this platform.
+var (
+	errDeepLinkDataInvalid    = errors.New("data is not a deep linking token issued by this platform")
+	errDeepLinkSessionClosed  = errors.New("deep linking session was already answered")
+	errDeepLinkSessionExpired = errors.New("deep linking session has expired")
+	errDeepLinkSessionForeign = errors.New("deep linking response was not sent by the tool the session was opened for")
+)
+
+// signDeepLinkData issues the opaque data value of a deep linking request: a platform-signed
+// token naming the session, addressed to the tool and expiring with the session.
+func (h *Handler) signDeepLinkData(s *repoIface.DeepLinkSession) (string, error) {
+	key, err := keys.SigningKey("")
+	if err != nil {
+		return "", err
+	}
+	tok, err := jwt.NewBuilder().
+		Issuer(h.issuer).
+		Audience([]string{s.ClientID}).
+		JwtID(s.ID).
+		IssuedAt(time.Now()).
+		Expiration(s.ExpiresAt).
+		Build()
+	if err != nil {
+		return "", err
+	}
+	signed, err := jwt.Sign(tok, jwt.WithKey(key.Algorithm(), key))
+	if err != nil {
+		return "", err
+	}
+	return string(signed), nil
+}
+
+// openDeepLinkSession resolves the session a response answers from its data token and checks that
+// it is still open and that iss (the responding tool) is the tool it was opened for.
+// Errors other than the errDeepLink* reasons are repository failures.
+func (h *Handler) openDeepLinkSession(ctx context.Context, data, iss string) (*repoIface.DeepLinkSession, error) {
+	set, err := keys.PublicKeySet()
+	if err != nil {
+		return nil, err
+	}
+	tok, err := jwt.ParseString(data, jwt.WithKeySet(set), jwt.WithValidate(true), jwt.WithIssuer(h.issuer))
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired()) {
+			return nil, errDeepLinkSessionExpired
+		}
+		return nil, errDeepLinkDataInvalid
+	}
+	s, err := h.repo.GetDeepLinkSession(ctx, tok.JwtID())
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, errDeepLinkDataInvalid
+	}
+	if iss != s.ClientID || !containsString(tok.Audience(), iss) {
+		return nil, errDeepLinkSessionForeign
+	}
+	if s.Status != repoIface.DeepLinkSessionOpen {
+		return nil, errDeepLinkSessionClosed
+	}
+	if time.Now().After(s.ExpiresAt) {
+		return nil, errDeepLinkSessionExpired
+	}
+	return s, nil
+}
+
+// setDeepLinkOutcome copies the tool's messages from the response onto s and derives its final status.
+func setDeepLinkOutcome(s *repoIface.DeepLinkSession, payload map[string]any) {
+	s.Msg, _ = payload[dlMsgClaim].(string)
+	s.Log, _ = payload[dlLogClaim].(string)
+	s.ErrorMsg, _ = payload[dlErrorMsgClaim].(string)
+	s.ErrorLog, _ = payload[dlErrorLogClaim].(string)
+	items, _ := payload[dlContentItemsClaim].([]any)
+	switch {
+	case s.ErrorMsg != "" || s.ErrorLog != "":
+		s.Status = repoIface.DeepLinkSessionFailed
+	case len(items) > 0:
+		s.Status = repoIface.DeepLinkSessionCompleted
+	default:
+		s.Status = repoIface.DeepLinkSessionCancelled
+	}
+}
+
+// This is NOT LTI Spec. Admin endpoints to inspect deep linking sessions.
+// listDeepLinkSessions GET /api/deeplink/sessions?context_id=
+func (h *Handler) listDeepLinkSessions(w http.ResponseWriter, r *http.Request) {
+	items, err := h.repo.ListDeepLinkSessions(r.Context(), r.URL.Query().Get("context_id"))
+	if err != nil {
+		logger.Error("list deep linking sessions: %v", err)
+		http.Error(w, "failed to list deep linking sessions", http.StatusInternalServerError)
+		return
+	}
+	if items == nil {
+		items = []*repoIface.DeepLinkSession{}
+	}
+	logger.Debug("listDeepLinkSessions: returned %d items", len(items))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(items)
+}
+
+// getDeepLinkSession GET /api/deeplink/sessions/{id}
+func (h *Handler) getDeepLinkSession(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	s, err := h.repo.GetDeepLinkSession(r.Context(), id)
+	if err != nil {
+		logger.Error("get deep linking session %s: %v", id, err)
+		http.Error(w, "failed to get deep linking session", http.StatusInternalServerError)
+		return
+	}
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s)
+}
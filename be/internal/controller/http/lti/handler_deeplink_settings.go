@@ -0,0 +1,107 @@
+package lti
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+
+	repoIface "github.com/quipper/poc/lti/be/pkg/repositories/lti"
+	vRepoIface "github.com/quipper/poc/lti/be/pkg/repositories/validation"
+)
+
+const dlSettingsClaim = "https://purl.imsglobal.org/spec/lti-dl/claim/deep_linking_settings"
+
+// presentationTargets are the accept_presentation_document_targets the platform can honour.
+var presentationTargets = []string{"iframe", "window", "embed"}
+
+// validateDeepLinkSettings rejects settings the platform cannot honour.
+func validateDeepLinkSettings(s *vRepoIface.DeepLinkSettings) error {
+	for _, t := range s.AcceptTypes {
+		if !containsString(repoIface.ContentItemTypes, t) {
+			return fmt.Errorf("unsupported accept_types entry: %s", t)
+		}
+	}
+	for _, t := range s.AcceptPresentationDocumentTargets {
+		if !containsString(presentationTargets, t) {
+			return fmt.Errorf("unsupported accept_presentation_document_targets entry: %s", t)
+		}
+	}
+	for _, mt := range splitMediaTypes(s.AcceptMediaTypes) {
+		if _, _, err := mime.ParseMediaType(mt); err != nil {
+			return fmt.Errorf("invalid accept_media_types entry: %s", mt)
+		}
+	}
+	return nil
+}
+
+// deepLinkSettingsWithDefaults fills the settings a launch left unset.
+func deepLinkSettingsWithDefaults(s *vRepoIface.DeepLinkSettings) vRepoIface.DeepLinkSettings {
+	var out vRepoIface.DeepLinkSettings
+	if s != nil {
+		out = *s
+	}
+	if len(out.AcceptTypes) == 0 {
+		out.AcceptTypes = repoIface.ContentItemTypes
+	}
+	if len(out.AcceptPresentationDocumentTargets) == 0 {
+		out.AcceptPresentationDocumentTargets = []string{"iframe", "window"}
+	}
+	if out.AcceptLineItem == nil {
+		acceptLineItem := true
+		out.AcceptLineItem = &acceptLineItem
+	}
+	return out
+}
+
+// deepLinkSettingsClaimValue builds the deep_linking_settings claim of an LtiDeepLinkingRequest.
+func (h *Handler) deepLinkSettingsClaimValue(s vRepoIface.DeepLinkSettings, data string) map[string]any {
+	claim := map[string]any{
+		"deep_link_return_url":                 h.issuer + "/api/deeplink/return",
+		"data":                                 data,
+		"accept_types":                         s.AcceptTypes,
+		"accept_presentation_document_targets": s.AcceptPresentationDocumentTargets,
+		"accept_multiple":                      s.AcceptMultiple,
+		"accept_lineitem":                      s.AcceptLineItem != nil && *s.AcceptLineItem,
+		"auto_create":                          s.AutoCreate,
+	}
+	if s.AcceptMediaTypes != "" {
+		claim["accept_media_types"] = s.AcceptMediaTypes
+	}
+	if s.Title != "" {
+		claim["title"] = s.Title
+	}
+	if s.Text != "" {
+		claim["text"] = s.Text
+	}
+	return claim
+}
+
+// mediaTypeAccepted reports whether mediaType matches accept, a comma-separated list that may
+// use wildcards such as image/*. An empty accept list accepts everything.
+func mediaTypeAccepted(accept, mediaType string) bool {
+	patterns := splitMediaTypes(accept)
+	if len(patterns) == 0 {
+		return true
+	}
+	mt, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return false
+	}
+	for _, p := range patterns {
+		p, _, _ = mime.ParseMediaType(p)
+		if p == mt || p == "*/*" || (strings.HasSuffix(p, "/*") && strings.HasPrefix(mt, strings.TrimSuffix(p, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitMediaTypes(s string) []string {
+	var out []string
+	for _, mt := range strings.Split(s, ",") {
+		if mt = strings.TrimSpace(mt); mt != "" {
+			out = append(out, mt)
+		}
+	}
+	return out
+}
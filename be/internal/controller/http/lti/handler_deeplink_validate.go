@@ -0,0 +1,131 @@
+package lti
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/quipper/poc/lti/be/pkg/common/logger"
+	repoPkg "github.com/quipper/poc/lti/be/pkg/repositories/lti"
+)
+
+const (
+	dlDataClaim         = "https://purl.imsglobal.org/spec/lti-dl/claim/data"
+	dlContentItemsClaim = "https://purl.imsglobal.org/spec/lti-dl/claim/content_items"
+	messageTypeClaim    = "https://purl.imsglobal.org/spec/lti/claim/message_type"
+	versionClaim        = "https://purl.imsglobal.org/spec/lti/claim/version"
+)
+
+// verifyDeepLinkResponse checks the response signature against the JWKS of the tool whose
+// client_id is iss. Returns nil when there is no such tool or its keys do not verify the token.
+func (h *Handler) verifyDeepLinkResponse(ctx context.Context, token, iss string) *repoPkg.Tool {
+	if iss == "" {
+		return nil
+	}
+	t, err := h.repo.GetToolByClientID(ctx, iss)
+	if err != nil || t == nil || t.KeySetURL == "" {
+		return nil
+	}
+	if _, err := h.parseWithToolJWKS(ctx, t, token, jwt.WithValidate(true)); err != nil {
+		logger.Debug("DeepLink return: tool=%s did not verify the response: %v", t.Name, err)
+		return nil
+	}
+	return t
+}
+
+// parseWithToolJWKS verifies token with the tool's JWKS from the cache. When the token's kid is
+// not in the cached set, the set is refetched once, so tools can rotate keys.
+func (h *Handler) parseWithToolJWKS(ctx context.Context, tool *repoPkg.Tool, token string, opts ...jwt.ParseOption) (jwt.Token, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	set, err := h.jwksCache.Get(ctx, tool.KeySetURL)
+	if err != nil {
+		return nil, err
+	}
+	if kid := tokenKeyID(token); kid != "" {
+		if _, ok := set.LookupKeyID(kid); !ok {
+			h.jwksCache.Invalidate(tool.KeySetURL)
+			if set, err = h.jwksCache.Get(ctx, tool.KeySetURL); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return jwt.ParseString(token, append([]jwt.ParseOption{jwt.WithKeySet(set)}, opts...)...)
+}
+
+// tokenKeyID returns the kid header of a compact JWS, or "" when it has none.
+func tokenKeyID(token string) string {
+	msg, err := jws.ParseString(token)
+	if err != nil || len(msg.Signatures()) == 0 {
+		return ""
+	}
+	return msg.Signatures()[0].ProtectedHeaders().KeyID()
+}
+
+// deepLinkResponseProblems lists the ways a deep linking response deviates from the spec and
+// from the session it answers.
+func (h *Handler) deepLinkResponseProblems(payload map[string]any, s *repoPkg.DeepLinkSession, verified *repoPkg.Tool) []string {
+	var problems []string
+	if verified == nil {
+		problems = append(problems, "signature was not verified by any registered tool")
+	} else if verified.ClientID != s.ClientID {
+		problems = append(problems, "signature was not made by the tool that received the request")
+	}
+	if depID, _ := payload[deploymentIDClaim].(string); depID != s.DeploymentID {
+		problems = append(problems, fmt.Sprintf("deployment_id %q does not match the request", depID))
+	}
+	if !audienceContains(payload["aud"], h.issuer) {
+		problems = append(problems, "aud does not include the platform issuer")
+	}
+	if nonce, _ := payload["nonce"].(string); nonce == "" {
+		problems = append(problems, "nonce is missing")
+	}
+	if mt, _ := payload[messageTypeClaim].(string); mt != "LtiDeepLinkingResponse" {
+		problems = append(problems, fmt.Sprintf("message_type %q is not LtiDeepLinkingResponse", mt))
+	}
+	if v, _ := payload[versionClaim].(string); v != "1.3.0" {
+		problems = append(problems, fmt.Sprintf("version %q is not 1.3.0", v))
+	}
+	items, _ := payload[dlContentItemsClaim].([]any)
+	if !s.AcceptMultiple && len(items) > 1 {
+		problems = append(problems, fmt.Sprintf("%d content items returned but accept_multiple is false", len(items)))
+	}
+	for i, it := range items {
+		m, _ := it.(map[string]any)
+		itemType, _ := m["type"].(string)
+		if !containsString(s.AcceptTypes, itemType) {
+			problems = append(problems, fmt.Sprintf("content item %d has type %q which is not in accept_types", i, itemType))
+		} else if item, err := parseContentItem(m); err != nil {
+			problems = append(problems, fmt.Sprintf("content item %d is invalid: %v", i, err))
+		} else if item.Type == repoPkg.ContentItemFile && !mediaTypeAccepted(s.AcceptMediaTypes, item.MediaType) {
+			problems = append(problems, fmt.Sprintf("content item %d has mediaType %q which is not in accept_media_types", i, item.MediaType))
+		}
+		if _, ok := m["lineItem"]; ok && !s.AcceptLineItem {
+			problems = append(problems, fmt.Sprintf("content item %d has a lineItem but accept_lineitem is false", i))
+		} else if ok {
+			if item, err := parseContentItem(m); err == nil {
+				if _, err := lineItemFromContentItem(m, item, s.ContextID); err != nil {
+					problems = append(problems, fmt.Sprintf("content item %d has an invalid lineItem: %v", i, err))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// audienceContains reports whether an aud claim (string or array) contains want.
+func audienceContains(aud any, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []any:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+package lti
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/quipper/poc/lti/be/pkg/common/logger"
+	repoIface "github.com/quipper/poc/lti/be/pkg/repositories/lti"
+)
+
+const deploymentIDClaim = "https://purl.imsglobal.org/spec/lti/claim/deployment_id"
+
+// defaultDeployment is the institution-wide deployment every newly registered tool starts with.
+func defaultDeployment(toolID int64) *repoIface.Deployment {
+	return &repoIface.Deployment{
+		ToolID:       toolID,
+		DeploymentID: uuid.NewString(),
+		Scope:        repoIface.DeploymentScopeInstitution,
+	}
+}
+
+// launchDeployment picks the deployment a launch runs under. An explicit deploymentID must
+// belong to the tool and cover the context; otherwise a course deployment for the context is
+// preferred over an institution one. Returns nil when no deployment applies.
+func (h *Handler) launchDeployment(ctx context.Context, tool *repoIface.Tool, deploymentID, contextID string) (*repoIface.Deployment, error) {
+	if deploymentID != "" {
+		d, err := h.repo.GetDeployment(ctx, tool.ID, deploymentID)
+		if err != nil || d == nil || !d.Covers(contextID) {
+			return nil, err
+		}
+		return d, nil
+	}
+	items, err := h.repo.ListDeployments(ctx, tool.ID)
+	if err != nil {
+		return nil, err
+	}
+	var fallback *repoIface.Deployment
+	for _, d := range items {
+		if !d.Covers(contextID) {
+			continue
+		}
+		if d.Scope == repoIface.DeploymentScopeCourse {
+			return d, nil
+		}
+		if fallback == nil {
+			fallback = d
+		}
+	}
+	return fallback, nil
+}
+
+// serviceTool returns the tool holding a service access token when it is deployed in contextID, else nil.
+// Tokens bound to a deployment (deployment_id in the client_assertion) are checked against that deployment only.
+func (h *Handler) serviceTool(ctx context.Context, tok jwt.Token, contextID string) (*repoIface.Tool, error) {
+	tool, err := h.repo.GetToolByClientID(ctx, tokenClientID(tok))
+	if err != nil || tool == nil {
+		return nil, err
+	}
+	if v, ok := tok.Get(deploymentIDClaim); ok {
+		depID, _ := v.(string)
+		d, err := h.repo.GetDeployment(ctx, tool.ID, depID)
+		if err != nil || d == nil || !d.Covers(contextID) {
+			return nil, err
+		}
+		return tool, nil
+	}
+	d, err := h.launchDeployment(ctx, tool, "", contextID)
+	if err != nil || d == nil {
+		return nil, err
+	}
+	return tool, nil
+}
+
+// tokenClientID returns the client_id an access token was issued to. Tokens issued before the
+// client_id claim was added carry it only as sub.
+func tokenClientID(tok jwt.Token) string {
+	if v, ok := tok.Get("client_id"); ok {
+		if s, _ := v.(string); s != "" {
+			return s
+		}
+	}
+	return tok.Subject()
+}
+
+// toolFromPath loads the tool named by the {id} route parameter, writing 400/404/500 on failure.
+func (h *Handler) toolFromPath(w http.ResponseWriter, r *http.Request) *repoIface.Tool {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return nil
+	}
+	tool, err := h.repo.GetToolByID(r.Context(), id)
+	if err != nil {
+		logger.Error("get tool by id %d: %v", id, err)
+		http.Error(w, "failed to get tool", http.StatusInternalServerError)
+		return nil
+	}
+	if tool == nil {
+		http.NotFound(w, r)
+		return nil
+	}
+	return tool
+}
+
+// listDeployments GET /api/tools/{id}/deployments
+func (h *Handler) listDeployments(w http.ResponseWriter, r *http.Request) {
+	tool := h.toolFromPath(w, r)
+	if tool == nil {
+		return
+	}
+	items, err := h.repo.ListDeployments(r.Context(), tool.ID)
+	if err != nil {
+		logger.Error("list deployments for tool %d: %v", tool.ID, err)
+		http.Error(w, "failed to list deployments", http.StatusInternalServerError)
+		return
+	}
+	if items == nil {
+		items = []*repoIface.Deployment{}
+	}
+	logger.Debug("listDeployments: tool=%d returned %d items", tool.ID, len(items))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(items)
+}
+
+// createDeployment POST /api/tools/{id}/deployments
+// body: {"deployment_id" (optional), "label", "scope": "institution"|"course", "context_id" (course scope)}
+func (h *Handler) createDeployment(w http.ResponseWriter, r *http.Request) {
+	tool := h.toolFromPath(w, r)
+	if tool == nil {
+		return
+	}
+	var req repoIface.Deployment
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	req.ToolID = tool.ID
+	req.DeploymentID = strings.TrimSpace(req.DeploymentID)
+	if req.DeploymentID == "" {
+		req.DeploymentID = uuid.NewString()
+	}
+	switch req.Scope {
+	case "", repoIface.DeploymentScopeInstitution:
+		req.Scope = repoIface.DeploymentScopeInstitution
+		req.ContextID = ""
+	case repoIface.DeploymentScopeCourse:
+		if strings.TrimSpace(req.ContextID) == "" {
+			http.Error(w, "context_id is required for course deployments", http.StatusBadRequest)
+			return
+		}
+		c, err := h.contexts.GetContext(r.Context(), req.ContextID)
+		if err != nil {
+			logger.Error("get context %s: %v", req.ContextID, err)
+			http.Error(w, "failed to create deployment", http.StatusInternalServerError)
+			return
+		}
+		if c == nil {
+			http.Error(w, "unknown context_id", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "scope must be institution or course", http.StatusBadRequest)
+		return
+	}
+	if existing, err := h.repo.GetDeployment(r.Context(), tool.ID, req.DeploymentID); err != nil {
+		logger.Error("get deployment: %v", err)
+		http.Error(w, "failed to create deployment", http.StatusInternalServerError)
+		return
+	} else if existing != nil {
+		http.Error(w, "deployment_id already exists for this tool", http.StatusConflict)
+		return
+	}
+	if _, err := h.repo.CreateDeployment(r.Context(), &req); err != nil {
+		logger.Error("create deployment: %v", err)
+		http.Error(w, "failed to create deployment", http.StatusInternalServerError)
+		return
+	}
+	logger.Debug("createDeployment: tool=%d deployment_id=%s scope=%s context_id=%s", tool.ID, req.DeploymentID, req.Scope, req.ContextID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(req)
+}
+
+// getDeployment GET /api/tools/{id}/deployments/{deploymentId}
+func (h *Handler) getDeployment(w http.ResponseWriter, r *http.Request) {
+	tool := h.toolFromPath(w, r)
+	if tool == nil {
+		return
+	}
+	depID := chi.URLParam(r, "deploymentId")
+	d, err := h.repo.GetDeployment(r.Context(), tool.ID, depID)
+	if err != nil {
+		logger.Error("get deployment %s: %v", depID, err)
+		http.Error(w, "failed to get deployment", http.StatusInternalServerError)
+		return
+	}
+	if d == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(d)
+}
+
+// deleteDeployment DELETE /api/tools/{id}/deployments/{deploymentId}
+func (h *Handler) deleteDeployment(w http.ResponseWriter, r *http.Request) {
+	tool := h.toolFromPath(w, r)
+	if tool == nil {
+		return
+	}
+	depID := chi.URLParam(r, "deploymentId")
+	if err := h.repo.DeleteDeployment(r.Context(), tool.ID, depID); err != nil {
+		logger.Error("delete deployment %s: %v", depID, err)
+		http.Error(w, "failed to delete deployment", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
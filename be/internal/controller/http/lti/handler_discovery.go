@@ -0,0 +1,67 @@
+package lti
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/quipper/poc/lti/be/pkg/common/keys"
+	repoIface "github.com/quipper/poc/lti/be/pkg/repositories/lti"
+)
+
+const ltiPlatformConfigurationClaim = "https://purl.imsglobal.org/spec/lti-platform-configuration"
+
+// tokenEndpoint is the advertised OAuth2 token URL.
+func (h *Handler) tokenEndpoint() string {
+	return h.publicBaseURL() + "/api/oauth2/token"
+}
+
+// tokenAudiences are the client_assertion audiences the token endpoint accepts: its advertised
+// URL and the issuer-based one, which differ when PUBLIC_BASE_URL is set.
+func (h *Handler) tokenAudiences() []string {
+	auds := []string{h.tokenEndpoint()}
+	if byIssuer := h.issuer + "/api/oauth2/token"; byIssuer != auds[0] {
+		auds = append(auds, byIssuer)
+	}
+	return auds
+}
+
+// openIDConfiguration serves the platform OpenID configuration used by Dynamic Registration.
+// GET /.well-known/openid-configuration
+func (h *Handler) openIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	if err := keys.Init(); err != nil {
+		http.Error(w, "failed to initialize keys", http.StatusInternalServerError)
+		return
+	}
+	base := h.publicBaseURL()
+	doc := map[string]any{
+		"issuer":                                h.issuer,
+		"authorization_endpoint":                base + "/api/oidc/auth",
+		"token_endpoint":                        h.tokenEndpoint(),
+		"token_endpoint_auth_methods_supported": []string{"private_key_jwt"},
+		"token_endpoint_auth_signing_alg_values_supported": []string{"RS256", "PS256", "ES256"},
+		"jwks_uri":               base + "/.well-known/jwks.json",
+		"introspection_endpoint": base + "/api/oauth2/introspect",
+		"introspection_endpoint_auth_methods_supported": []string{"private_key_jwt"},
+		"revocation_endpoint":                           base + "/api/oauth2/revoke",
+		"revocation_endpoint_auth_methods_supported":    []string{"private_key_jwt"},
+		"registration_endpoint":                         base + "/api/registration",
+		"scopes_supported":                              append([]string{"openid"}, repoIface.ServiceScopes...),
+		"response_types_supported":                      []string{"id_token"},
+		"response_modes_supported":                      []string{"form_post"},
+		"grant_types_supported":                         []string{"implicit", "client_credentials"},
+		"subject_types_supported":                       []string{"public"},
+		"id_token_signing_alg_values_supported":         keys.EnabledAlgs(),
+		"claims_supported":                              []string{"iss", "aud", "sub", "exp", "iat", "nonce", "name", "given_name", "family_name", "email"},
+		ltiPlatformConfigurationClaim: map[string]any{
+			"product_family_code": "lti-go-platform",
+			"version":             "1.0",
+			"messages_supported": []map[string]any{
+				{"type": "LtiResourceLinkRequest"},
+				{"type": "LtiDeepLinkingRequest"},
+			},
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_ = json.NewEncoder(w).Encode(doc)
+}
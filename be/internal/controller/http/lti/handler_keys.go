@@ -0,0 +1,75 @@
+package lti
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/quipper/poc/lti/be/pkg/common/keys"
+	"github.com/quipper/poc/lti/be/pkg/common/logger"
+	keysRepo "github.com/quipper/poc/lti/be/pkg/repositories/keys"
+)
+
+// This is NOT LTI Spec. Admin endpoints to manage the platform signing key ring.
+// adminListKeys GET /api/admin/keys
+func (h *Handler) adminListKeys(w http.ResponseWriter, r *http.Request) {
+	items, err := keys.List()
+	if err != nil {
+		logger.Error("list keys: %v", err)
+		http.Error(w, "failed to list keys", http.StatusInternalServerError)
+		return
+	}
+	logger.Debug("adminListKeys: returned %d keys", len(items))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(items)
+}
+
+// adminCreateKey POST /api/admin/keys  body: {"alg": "ES256"} (optional, defaults to the platform default alg)
+// Creates a new active signing key; the previous one for that alg stays published for the grace period.
+func (h *Handler) adminCreateKey(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Alg string `json:"alg"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+	kid, err := keys.Rotate(r.Context(), jwa.SignatureAlgorithm(body.Alg))
+	if err != nil {
+		if errors.Is(err, keys.ErrUnsupportedAlg) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Error("rotate key: %v", err)
+		http.Error(w, "failed to create key", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("adminCreateKey: new active kid=%s alg=%s", kid, body.Alg)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]any{"kid": kid})
+}
+
+// adminRetireKey DELETE /api/admin/keys/{kid}
+// Removes a rotated-out key from the JWKS immediately.
+func (h *Handler) adminRetireKey(w http.ResponseWriter, r *http.Request) {
+	kid := chi.URLParam(r, "kid")
+	if err := keys.Retire(r.Context(), kid); err != nil {
+		switch {
+		case errors.Is(err, keysRepo.ErrNotFound):
+			http.NotFound(w, r)
+		case errors.Is(err, keys.ErrActiveKey):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			logger.Error("retire key %s: %v", kid, err)
+			http.Error(w, "failed to retire key", http.StatusInternalServerError)
+		}
+		return
+	}
+	logger.Info("adminRetireKey: retired kid=%s", kid)
+	w.WriteHeader(http.StatusNoContent)
+}
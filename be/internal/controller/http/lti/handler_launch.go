@@ -4,10 +4,12 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/quipper/poc/lti/be/pkg/common/logger"
+	vRepoIface "github.com/quipper/poc/lti/be/pkg/repositories/validation"
 )
 
 // launchStart starts the LTI 1.3 third-party initiated login by redirecting
@@ -15,14 +17,18 @@ import (
 func (h *Handler) launchStart(w http.ResponseWriter, r *http.Request) {
 	logger.Debug("launchStart: method=%s content_type=%s", r.Method, r.Header.Get("Content-Type"))
 	type reqBody struct {
-		Issuer             string `json:"issuer"`
-		ClientID           string `json:"client_id"`
-		LoginInitiationURL string `json:"login_initiation_url"`
-		TargetLinkURI      string `json:"target_link_uri"`
-		ContextID          string `json:"context_id"`
-		LoginHint          string `json:"login_hint"`
-		LTIMessageHint     string `json:"lti_message_hint"`
-		ResourceLinkID     string `json:"resource_link_id"`
+		Issuer             string   `json:"issuer"`
+		ClientID           string   `json:"client_id"`
+		LoginInitiationURL string   `json:"login_initiation_url"`
+		TargetLinkURI      string   `json:"target_link_uri"`
+		ContextID          string   `json:"context_id"`
+		LoginHint          string   `json:"login_hint"`
+		LTIMessageHint     string   `json:"lti_message_hint"`
+		ResourceLinkID     string   `json:"resource_link_id"`
+		DeploymentID       string   `json:"deployment_id"`
+		AcceptTypes        []string `json:"accept_types"` // shorthand for deep_linking_settings.accept_types
+		// DeepLinkingSettings are reproduced in the deep_linking_settings claim of deep linking launches.
+		DeepLinkingSettings *vRepoIface.DeepLinkSettings `json:"deep_linking_settings"`
 	}
 
 	var body reqBody
@@ -44,6 +50,21 @@ func (h *Handler) launchStart(w http.ResponseWriter, r *http.Request) {
 		body.LoginHint = r.FormValue("login_hint")
 		body.LTIMessageHint = r.FormValue("lti_message_hint")
 		body.ResourceLinkID = r.FormValue("resource_link_id")
+		body.DeploymentID = r.FormValue("deployment_id")
+		for _, v := range r.Form["accept_types"] {
+			for _, t := range strings.Split(v, ",") {
+				if t = strings.TrimSpace(t); t != "" {
+					body.AcceptTypes = append(body.AcceptTypes, t)
+				}
+			}
+		}
+		if v := r.FormValue("deep_linking_settings"); v != "" {
+			body.DeepLinkingSettings = &vRepoIface.DeepLinkSettings{}
+			if err := json.Unmarshal([]byte(v), body.DeepLinkingSettings); err != nil {
+				http.Error(w, "invalid deep_linking_settings", http.StatusBadRequest)
+				return
+			}
+		}
 
 		logger.Debug("issuer: %s", body.Issuer)
 		logger.Debug("client_id: %s", body.ClientID)
@@ -53,12 +74,97 @@ func (h *Handler) launchStart(w http.ResponseWriter, r *http.Request) {
 		logger.Debug("login_hint: %s", body.LoginHint)
 		logger.Debug("lti_message_hint: %s", body.LTIMessageHint)
 		logger.Debug("resource_link_id: %s", body.ResourceLinkID)
+		logger.Debug("deployment_id: %s", body.DeploymentID)
+		logger.Debug("accept_types: %v", body.AcceptTypes)
+		logger.Debug("deep_linking_settings: %s", r.FormValue("deep_linking_settings"))
 	}
 	if body.Issuer == "" || body.ClientID == "" || body.LoginInitiationURL == "" || body.TargetLinkURI == "" {
 		logger.Debug("launchStart: missing required fields issuer/client_id/login_initiation_url/target_link_uri")
 		http.Error(w, "missing required fields: issuer, client_id, login_initiation_url, target_link_uri", http.StatusBadRequest)
 		return
 	}
+	if len(body.AcceptTypes) > 0 {
+		if body.DeepLinkingSettings == nil {
+			body.DeepLinkingSettings = &vRepoIface.DeepLinkSettings{}
+		}
+		if len(body.DeepLinkingSettings.AcceptTypes) == 0 {
+			body.DeepLinkingSettings.AcceptTypes = body.AcceptTypes
+		}
+	}
+	if body.DeepLinkingSettings != nil {
+		if err := validateDeepLinkSettings(body.DeepLinkingSettings); err != nil {
+			logger.Debug("launchStart: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if body.ContextID != "" {
+		c, err := h.contexts.GetContext(r.Context(), body.ContextID)
+		if err != nil {
+			logger.Error("launchStart: get context: %v", err)
+			http.Error(w, "repository error", http.StatusInternalServerError)
+			return
+		}
+		if c == nil {
+			logger.Debug("launchStart: unknown context_id=%s", body.ContextID)
+			http.Error(w, "unknown context_id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Resolve the deployment the launch runs under; course deployments only cover their context.
+	tool, err := h.repo.GetToolByClientID(r.Context(), body.ClientID)
+	if err != nil {
+		logger.Error("launchStart: get tool: %v", err)
+		http.Error(w, "repository error", http.StatusInternalServerError)
+		return
+	}
+	if tool == nil {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	// A resource link launch runs in the link's context and deployment unless the caller names them.
+	if body.ResourceLinkID != "" {
+		link, err := h.repo.GetResourceLink(r.Context(), body.ResourceLinkID)
+		if err != nil {
+			logger.Error("launchStart: get resource link: %v", err)
+			http.Error(w, "repository error", http.StatusInternalServerError)
+			return
+		}
+		if link == nil {
+			logger.Debug("launchStart: unknown resource_link_id=%s", body.ResourceLinkID)
+			http.Error(w, "unknown resource_link_id", http.StatusBadRequest)
+			return
+		}
+		// Links migrated from unverified deep link selections carry no tool.
+		if link.ToolID != 0 && link.ToolID != tool.ID {
+			http.Error(w, "resource link belongs to another tool", http.StatusBadRequest)
+			return
+		}
+		if link.ContextID != "" {
+			if body.ContextID == "" {
+				body.ContextID = link.ContextID
+			} else if body.ContextID != link.ContextID {
+				http.Error(w, "resource link belongs to another context", http.StatusBadRequest)
+				return
+			}
+		}
+		if body.DeploymentID == "" {
+			body.DeploymentID = link.DeploymentID
+		}
+	}
+	deployment, err := h.launchDeployment(r.Context(), tool, body.DeploymentID, body.ContextID)
+	if err != nil {
+		logger.Error("launchStart: resolve deployment: %v", err)
+		http.Error(w, "repository error", http.StatusInternalServerError)
+		return
+	}
+	if deployment == nil {
+		logger.Debug("launchStart: no deployment of client_id=%s (deployment_id=%q) covers context_id=%s", body.ClientID, body.DeploymentID, body.ContextID)
+		http.Error(w, "tool is not deployed for this context", http.StatusBadRequest)
+		return
+	}
 
 	// Build redirect URL with required params.
 	u, err := url.Parse(body.LoginInitiationURL)
@@ -70,6 +176,7 @@ func (h *Handler) launchStart(w http.ResponseWriter, r *http.Request) {
 	q := u.Query()
 	q.Set("iss", body.Issuer)
 	q.Set("client_id", body.ClientID)
+	q.Set("lti_deployment_id", deployment.DeploymentID)
 
 	if body.LTIMessageHint != "" {
 		q.Set("lti_message_hint", body.LTIMessageHint)
@@ -77,11 +184,19 @@ func (h *Handler) launchStart(w http.ResponseWriter, r *http.Request) {
 
 	q.Set("target_link_uri", body.TargetLinkURI)
 
-	// For POC it retrieves email from body
-	// TODO: Get email from user session/ DB
-	if body.LoginHint != "" {
-		q.Set("login_hint", body.LoginHint)
+	// Bind the launch to a platform user; the tool echoes login_hint back to oidcAuth.
+	user, err := h.launchUser(r, body.LoginHint)
+	if err != nil {
+		logger.Error("launchStart: resolve user: %v", err)
+		http.Error(w, "failed to resolve user", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		logger.Debug("launchStart: unknown platform user login_hint=%s", body.LoginHint)
+		http.Error(w, "unknown platform user", http.StatusForbidden)
+		return
 	}
+	q.Set("login_hint", user.ID)
 
 	if body.ResourceLinkID != "" {
 		q.Set("resource_link_id", body.ResourceLinkID)
@@ -96,8 +211,15 @@ func (h *Handler) launchStart(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "validation repository not configured", http.StatusInternalServerError)
 		return
 	}
-	if err := h.validationRepo.CreateOIDCState(r.Context(), state, body.ClientID,
-		body.TargetLinkURI, body.ContextID, body.ResourceLinkID, exp); err != nil {
+	if err := h.validationRepo.CreateOIDCState(r.Context(), state, &vRepoIface.OIDCState{
+		ClientID:       body.ClientID,
+		TargetLinkURI:  body.TargetLinkURI,
+		ContextID:      body.ContextID,
+		ResourceLinkID: body.ResourceLinkID,
+		UserID:         user.ID,
+		DeploymentID:   deployment.DeploymentID,
+		DeepLinking:    body.DeepLinkingSettings,
+	}, exp); err != nil {
 		logger.Debug("launchStart: failed to create state: %v", err)
 		http.Error(w, "failed to create state", http.StatusInternalServerError)
 		return
@@ -30,19 +30,7 @@ func (h *Handler) nrpsListMembers(w http.ResponseWriter, r *http.Request) {
 		r.Body = io.NopCloser(bytes.NewReader(nil))
 	}
 	// Pagination params
-	q := r.URL.Query()
-	limit := 50
-	if ls := q.Get("limit"); ls != "" {
-		if v, err := strconv.Atoi(ls); err == nil && v > 0 {
-			limit = v
-		}
-	}
-	offset := 0
-	if os := q.Get("offset"); os != "" {
-		if v, err := strconv.Atoi(os); err == nil && v >= 0 {
-			offset = v
-		}
-	}
+	offset, limit := pageParams(r)
 	logger.Debug("NRPS list members: context_id=%s offset=%d limit=%d", contextID, offset, limit)
 	// DB-level pagination
 	page, total, err := h.roster.ListMembersPage(ctx, contextID, offset, limit)
@@ -51,6 +39,12 @@ func (h *Handler) nrpsListMembers(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	// requireKnownContext has already checked the context exists; it supplies label/title.
+	contextInfo := map[string]any{"id": contextID}
+	if c, err := h.contexts.GetContext(ctx, contextID); err == nil && c != nil {
+		contextInfo = contextClaimValue(c)
+		delete(contextInfo, "type")
+	}
 	w.Header().Set("Content-Type", "application/json")
 	containerID := absoluteURL(r)
 	// Set Link: rel="next" if more pages
@@ -65,7 +59,7 @@ func (h *Handler) nrpsListMembers(w http.ResponseWriter, r *http.Request) {
 	logger.Debug("NRPS list members ok: returned=%d total=%d has_next=%v", len(page), total, offset+limit < total)
 	resp := map[string]any{
 		"id":      containerID,
-		"context": map[string]any{"id": contextID},
+		"context": contextInfo,
 		"members": page,
 	}
 	if b, err := json.Marshal(resp); err == nil {
@@ -136,6 +130,24 @@ func schemeHost(r *http.Request) (string, string) {
 	return scheme, host
 }
 
+// pageParams reads the offset and limit query parameters, defaulting to 0 and 50 and
+// ignoring invalid values.
+func pageParams(r *http.Request) (offset, limit int) {
+	q := r.URL.Query()
+	limit = 50
+	if ls := q.Get("limit"); ls != "" {
+		if v, err := strconv.Atoi(ls); err == nil && v > 0 {
+			limit = v
+		}
+	}
+	if os := q.Get("offset"); os != "" {
+		if v, err := strconv.Atoi(os); err == nil && v >= 0 {
+			offset = v
+		}
+	}
+	return offset, limit
+}
+
 func buildPageURL(r *http.Request, offset, limit int) string {
 	scheme, host := schemeHost(r)
 	u := url.URL{Scheme: scheme, Host: host, Path: r.URL.Path}
@@ -4,7 +4,7 @@ import (
 	"net/http"
 	"strings"
 
-	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/go-chi/chi/v5"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 	"github.com/quipper/poc/lti/be/pkg/common/keys"
 )
@@ -13,7 +13,29 @@ import (
 func (h *Handler) nrpsRequireScopes(scopes ...string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if ok := requireBearerScopes(w, r, scopes); !ok {
+			tok, ok := requireBearerScopes(w, r, scopes)
+			if !ok {
+				return
+			}
+			// Revoked tokens are rejected before they expire.
+			active, err := h.accessTokenActive(r.Context(), tok)
+			if err != nil {
+				http.Error(w, "repositoryError", http.StatusInternalServerError)
+				return
+			}
+			if !active {
+				w.Header().Set("WWW-Authenticate", "Bearer error=\"invalid_token\"")
+				http.Error(w, "invalidToken", http.StatusUnauthorized)
+				return
+			}
+			// The tool must be deployed in the context whose roster it reads.
+			tool, err := h.serviceTool(r.Context(), tok, chi.URLParam(r, "contextId"))
+			if err != nil {
+				http.Error(w, "repositoryError", http.StatusInternalServerError)
+				return
+			}
+			if tool == nil {
+				http.Error(w, "toolNotDeployedInContext", http.StatusForbidden)
 				return
 			}
 			next.ServeHTTP(w, r)
@@ -22,31 +44,26 @@ func (h *Handler) nrpsRequireScopes(scopes ...string) func(http.Handler) http.Ha
 }
 
 // requireBearerScopes validates Authorization: Bearer access token and required scopes.
-// Returns false and writes an error response when invalid.
-func requireBearerScopes(w http.ResponseWriter, r *http.Request, required []string) bool {
+// Returns the parsed token, or false after writing an error response when invalid.
+func requireBearerScopes(w http.ResponseWriter, r *http.Request, required []string) (jwt.Token, bool) {
 	auth := r.Header.Get("Authorization")
 	if auth == "" || !strings.HasPrefix(strings.ToLower(auth), "bearer ") {
 		w.Header().Set("WWW-Authenticate", "Bearer realm=\"NRPS\"")
 		http.Error(w, "missingAuthorization", http.StatusUnauthorized)
-		return false
+		return nil, false
 	}
 	tokenStr := strings.TrimSpace(auth[len("Bearer "):])
-	if err := keys.Init(); err != nil {
+	// Verify JWT against the platform key ring (in this PoC we issue tokens ourselves)
+	set, err := keys.PublicKeySet()
+	if err != nil {
 		http.Error(w, "serverKeyInitFailed", http.StatusInternalServerError)
-		return false
+		return nil, false
 	}
-	// Verify JWT using platform key (in this PoC we issue tokens ourselves)
-	priv := keys.PrivateKey()
-	if priv == nil {
-		http.Error(w, "signingKeyUnavailable", http.StatusInternalServerError)
-		return false
-	}
-	pub := priv.Public()
-	tok, err := jwt.Parse([]byte(tokenStr), jwt.WithKey(jwa.RS256, pub))
+	tok, err := jwt.Parse([]byte(tokenStr), jwt.WithKeySet(set))
 	if err != nil {
 		w.Header().Set("WWW-Authenticate", "Bearer error=\"invalid_token\"")
 		http.Error(w, "invalidToken", http.StatusUnauthorized)
-		return false
+		return nil, false
 	}
 	// Validate scope claim contains all required scopes
 	scopesOK := false
@@ -56,9 +73,9 @@ func requireBearerScopes(w http.ResponseWriter, r *http.Request, required []stri
 	if !scopesOK {
 		w.Header().Set("WWW-Authenticate", "Bearer error=\"insufficient_scope\"")
 		http.Error(w, "insufficientScope", http.StatusForbidden)
-		return false
+		return nil, false
 	}
-	return true
+	return tok, true
 }
 
 func hasAllScopes(scopeClaim any, required []string) bool {
@@ -8,13 +8,12 @@ import (
 	"strings"
 	"time"
 
-	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/lestrrat-go/jwx/v2/jwk"
-	"github.com/lestrrat-go/jwx/v2/jws"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 	"github.com/quipper/poc/lti/be/pkg/common/keys"
 	"github.com/quipper/poc/lti/be/pkg/common/logger"
 	ltiRepo "github.com/quipper/poc/lti/be/pkg/repositories/lti"
+	vRepoIface "github.com/quipper/poc/lti/be/pkg/repositories/validation"
 )
 
 // LTI Tools use this endpoint to obtain an access token.
@@ -26,13 +25,133 @@ func (h *Handler) oauth2Token(w http.ResponseWriter, r *http.Request) {
 	}
 	grantType := r.Form.Get("grant_type")
 	scope := r.Form.Get("scope")
-	clientID := r.Form.Get("client_id")
-	clientAssertionType := r.Form.Get("client_assertion_type")
-	clientAssertion := r.Form.Get("client_assertion")
 
 	// Debug: log received fields (not logging full assertions)
 	logger.Debug("/api/oauth2/token: grant_type=%s scope=%q client_id=%q client_assertion_type=%q has_assertion=%t",
-		grantType, scope, clientID, clientAssertionType, clientAssertion != "")
+		grantType, scope, r.Form.Get("client_id"), r.Form.Get("client_assertion_type"), r.Form.Get("client_assertion") != "")
+
+	// Basic validation according to IMS LTI services: client_credentials + private_key_jwt
+	if grantType != "client_credentials" {
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be client_credentials")
+		return
+	}
+	if scope == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "missing scope")
+		return
+	}
+
+	client, authErr := h.authenticateClient(r)
+	if authErr != nil {
+		writeOAuthError(w, authErr.status, authErr.code, authErr.desc)
+		return
+	}
+	tool, parsed, effectiveClientID := client.tool, client.assertion, client.clientID
+
+	// Grant only the requested scopes the tool is allowed (RFC 6749 section 3.3).
+	granted := allowedScopes(tool, scope)
+	if len(granted) == 0 {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_scope", "none of the requested scopes is allowed for this client")
+		return
+	}
+	grantedScope := strings.Join(granted, " ")
+
+	// Bind the token to a deployment when the tool names one in its client_assertion.
+	deploymentID := ""
+	if v, ok := parsed.Get(deploymentIDClaim); ok {
+		deploymentID, _ = v.(string)
+		d, err := h.repo.GetDeployment(r.Context(), tool.ID, deploymentID)
+		if err != nil {
+			writeOAuthError(w, http.StatusInternalServerError, "server_error", "repository error")
+			return
+		}
+		if d == nil {
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client_assertion deployment_id is not a deployment of this client")
+			return
+		}
+	}
+
+	// Issue a JWT access token signed by the active platform key (default algorithm)
+	signingKey, err := keys.SigningKey("")
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to load signing key")
+		return
+	}
+	now := time.Now()
+	//TODO: extend token expiry to 1 hour
+	//this short time is for easier logging since Tool will cache the token and will not call /oauth2/token again
+	exp2 := now.Add(1 * time.Minute)
+	aud := h.issuer + "/api" // audience for your APIs; adjust per service if needed
+	tokenID := anonSub()
+	builder := jwt.NewBuilder().
+		Issuer(h.issuer).
+		Subject(effectiveClientID).
+		Audience([]string{aud}).
+		IssuedAt(now).
+		Expiration(exp2).
+		JwtID(tokenID).
+		Claim("client_id", effectiveClientID).
+		Claim("scope", grantedScope)
+	if deploymentID != "" {
+		builder = builder.Claim(deploymentIDClaim, deploymentID)
+	}
+	accessJWT, err := builder.Build()
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to build access token")
+		return
+	}
+
+	rawToken, err := jwt.Sign(accessJWT, jwt.WithKey(signingKey.Algorithm(), signingKey))
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to sign access token")
+		return
+	}
+
+	// Record the token so it can be introspected and revoked.
+	if err := h.validationRepo.RecordIssuedToken(r.Context(), &vRepoIface.IssuedToken{
+		JTI:          tokenID,
+		ClientID:     effectiveClientID,
+		Scope:        grantedScope,
+		DeploymentID: deploymentID,
+		IssuedAt:     now,
+		ExpiresAt:    exp2,
+	}); err != nil {
+		logger.Error("/api/oauth2/token: record issued token: %v", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "repository error")
+		return
+	}
+
+	resp := map[string]any{
+		"access_token": string(rawToken),
+		"token_type":   "Bearer",
+		"expires_in":   int(time.Until(exp2).Seconds()),
+		"scope":        grantedScope,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+	logger.Debug("/api/oauth2/token: issued token client_id=%s scope=%q exp=%s", effectiveClientID, grantedScope, exp2.Format(time.RFC3339))
+}
+
+// authenticatedClient is a tool that proved its identity with a private_key_jwt client_assertion.
+type authenticatedClient struct {
+	tool      *ltiRepo.Tool
+	assertion jwt.Token
+	// clientID is the registered client_id, or the assertion's sub/iss when the tool has none.
+	clientID string
+}
+
+// clientAuthError is the RFC 6749 error response for a failed client authentication.
+type clientAuthError struct {
+	status     int
+	code, desc string
+}
+
+// authenticateClient verifies the client_assertion of a parsed form request (RFC 7523) against
+// the JWKS of the tool it names, and consumes its jti so it cannot be replayed.
+// It authenticates callers of the token, introspection and revocation endpoints.
+func (h *Handler) authenticateClient(r *http.Request) (*authenticatedClient, *clientAuthError) {
+	clientID := r.Form.Get("client_id")
+	clientAssertionType := r.Form.Get("client_assertion_type")
+	clientAssertion := r.Form.Get("client_assertion")
 	if clientAssertion != "" {
 		// Try to log JWT header kid and payload aud without verifying
 		parts := strings.Split(clientAssertion, ".")
@@ -64,24 +183,12 @@ func (h *Handler) oauth2Token(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Basic validation according to IMS LTI services: client_credentials + private_key_jwt
-	if grantType != "client_credentials" {
-		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be client_credentials")
-		return
-	}
-	if scope == "" {
-		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "missing scope")
-		return
-	}
-
 	// Validate client assertion type
 	if clientAssertionType != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" {
-		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "invalid client_assertion_type")
-		return
+		return nil, &clientAuthError{http.StatusBadRequest, "invalid_request", "invalid client_assertion_type"}
 	}
 	if clientAssertion == "" {
-		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "missing client_assertion")
-		return
+		return nil, &clientAuthError{http.StatusBadRequest, "invalid_request", "missing client_assertion"}
 	}
 
 	// Lookup tool by client_id. Some tools omit client_id when using private_key_jwt.
@@ -89,14 +196,23 @@ func (h *Handler) oauth2Token(w http.ResponseWriter, r *http.Request) {
 	var tool *ltiRepo.Tool
 	var parsed jwt.Token
 	var err error
-	tokenEndpoint := h.issuer + "/api/oauth2/token"
+	// aud must name the token endpoint by either of its URLs.
+	audiences := h.tokenAudiences()
+	audienceOK := jwt.ValidatorFunc(func(_ context.Context, t jwt.Token) jwt.ValidationError {
+		for _, aud := range audiences {
+			if containsString(t.Audience(), aud) {
+				return nil
+			}
+		}
+		return jwt.ErrInvalidAudience()
+	})
 
 	// Helper to parse with a given key set
 	parseWithSet := func(set jwk.Set) (jwt.Token, error) {
 		return jwt.ParseString(clientAssertion,
 			jwt.WithKeySet(set),
 			jwt.WithValidate(true),
-			jwt.WithAudience(tokenEndpoint),
+			jwt.WithValidator(audienceOK),
 		)
 	}
 
@@ -104,8 +220,7 @@ func (h *Handler) oauth2Token(w http.ResponseWriter, r *http.Request) {
 	if clientID != "" {
 		t, err1 := h.repo.GetToolByClientID(r.Context(), clientID)
 		if err1 != nil {
-			writeOAuthError(w, http.StatusInternalServerError, "server_error", "repository error")
-			return
+			return nil, &clientAuthError{http.StatusInternalServerError, "server_error", "repository error"}
 		}
 		if t != nil {
 			ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
@@ -146,8 +261,7 @@ func (h *Handler) oauth2Token(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	if parsed == nil || tool == nil {
-		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "unable to validate client_assertion against any registered tool")
-		return
+		return nil, &clientAuthError{http.StatusUnauthorized, "invalid_client", "unable to validate client_assertion against any registered tool"}
 	}
 
 	// Fetch tool JWKS
@@ -155,19 +269,13 @@ func (h *Handler) oauth2Token(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 	set, err := h.jwksCache.Get(ctx, tool.KeySetURL)
 	if err != nil {
-		writeOAuthError(w, http.StatusBadGateway, "invalid_client", "failed to fetch client JWKS")
-		return
+		return nil, &clientAuthError{http.StatusBadGateway, "invalid_client", "failed to fetch client JWKS"}
 	}
 
 	// Validate client_assertion JWT signature and claims
-	parsed, err = jwt.ParseString(clientAssertion,
-		jwt.WithKeySet(set),
-		jwt.WithValidate(true),
-		jwt.WithAudience(tokenEndpoint),
-	)
+	parsed, err = parseWithSet(set)
 	if err != nil {
-		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "invalid client_assertion: "+err.Error())
-		return
+		return nil, &clientAuthError{http.StatusUnauthorized, "invalid_client", "invalid client_assertion: " + err.Error()}
 	}
 
 	// Additional consistency checks (relaxed for interop): accept if either iss or sub matches registered client_id
@@ -176,12 +284,10 @@ func (h *Handler) oauth2Token(w http.ResponseWriter, r *http.Request) {
 	logger.Debug("client_assertion iss=%q sub=%q registered_client_id=%q", iss, sub, tool.ClientID)
 	if tool.ClientID != "" {
 		if iss != tool.ClientID && sub != tool.ClientID {
-			writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client_assertion iss/sub do not match registered client_id")
-			return
+			return nil, &clientAuthError{http.StatusUnauthorized, "invalid_client", "client_assertion iss/sub do not match registered client_id"}
 		}
 	} else if iss == "" && sub == "" {
-		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client_assertion missing iss/sub")
-		return
+		return nil, &clientAuthError{http.StatusUnauthorized, "invalid_client", "client_assertion missing iss/sub"}
 	}
 	// Use the registered client_id when available, otherwise fallback to sub then iss
 	effectiveClientID := tool.ClientID
@@ -196,65 +302,20 @@ func (h *Handler) oauth2Token(w http.ResponseWriter, r *http.Request) {
 	// Enforce jti uniqueness to prevent replay
 	jti := parsed.JwtID()
 	if jti == "" {
-		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client_assertion missing jti")
-		return
+		return nil, &clientAuthError{http.StatusUnauthorized, "invalid_client", "client_assertion missing jti"}
 	}
 	exp := parsed.Expiration()
 	if h.validationRepo == nil {
-		writeOAuthError(w, http.StatusInternalServerError, "server_error", "validation repository not configured")
-		return
+		return nil, &clientAuthError{http.StatusInternalServerError, "server_error", "validation repository not configured"}
 	}
 	ok, err := h.validationRepo.TryUseClientAssertionJTI(r.Context(), jti, effectiveClientID, exp)
 	if err != nil {
-		writeOAuthError(w, http.StatusInternalServerError, "server_error", "repository error")
-		return
+		return nil, &clientAuthError{http.StatusInternalServerError, "server_error", "repository error"}
 	}
 	if !ok {
-		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client_assertion replay detected")
-		return
-	}
-
-	// Issue a JWT access token signed by platform keys
-	if err := keys.Init(); err != nil {
-		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to initialize keys")
-		return
-	}
-	now := time.Now()
-	//TODO: extend token expiry to 1 hour
-	//this short time is for easier logging since Tool will cache the token and will not call /oauth2/token again
-	exp2 := now.Add(1 * time.Minute)
-	aud := h.issuer + "/api" // audience for your APIs; adjust per service if needed
-	accessJWT, err := jwt.NewBuilder().
-		Issuer(h.issuer).
-		Subject(effectiveClientID).
-		Audience([]string{aud}).
-		IssuedAt(now).
-		Expiration(exp2).
-		JwtID(anonSub()).
-		Claim("scope", scope).
-		Build()
-	if err != nil {
-		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to build access token")
-		return
-	}
-
-	hdrs := jws.NewHeaders()
-	_ = hdrs.Set(jwk.KeyIDKey, keys.Kid())
-	rawToken, err := jwt.Sign(accessJWT, jwt.WithKey(jwa.RS256, keys.PrivateKey(), jws.WithProtectedHeaders(hdrs)))
-	if err != nil {
-		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to sign access token")
-		return
+		return nil, &clientAuthError{http.StatusUnauthorized, "invalid_client", "client_assertion replay detected"}
 	}
-
-	resp := map[string]any{
-		"access_token": string(rawToken),
-		"token_type":   "Bearer",
-		"expires_in":   int(time.Until(exp2).Seconds()),
-		"scope":        scope,
-	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(resp)
-	logger.Debug("/api/oauth2/token: issued token client_id=%s scope=%q exp=%s", effectiveClientID, scope, exp2.Format(time.RFC3339))
+	return &authenticatedClient{tool: tool, assertion: parsed, clientID: effectiveClientID}, nil
 }
 
 // writeOAuthError writes an RFC 6749 style error response
@@ -265,5 +326,5 @@ func writeOAuthError(w http.ResponseWriter, status int, code, desc string) {
 		"error":             code,
 		"error_description": desc,
 	})
-	logger.Debug("/api/oauth2/token error: status=%d error=%s desc=%s", status, code, desc)
+	logger.Debug("oauth2 error: status=%d error=%s desc=%s", status, code, desc)
 }
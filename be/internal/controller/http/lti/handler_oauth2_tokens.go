@@ -0,0 +1,181 @@
+package lti
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/quipper/poc/lti/be/pkg/common/keys"
+	"github.com/quipper/poc/lti/be/pkg/common/logger"
+)
+
+// accessTokenActive reports whether a verified access token was issued by oauth2Token and has
+// not been revoked or expired since.
+func (h *Handler) accessTokenActive(ctx context.Context, tok jwt.Token) (bool, error) {
+	if h.validationRepo == nil {
+		return false, nil
+	}
+	t, err := h.validationRepo.GetIssuedToken(ctx, tok.JwtID())
+	if err != nil || t == nil {
+		return false, err
+	}
+	return t.RevokedAt == nil && time.Now().Before(t.ExpiresAt), nil
+}
+
+// oauth2Introspect POST /api/oauth2/introspect (RFC 7662)
+// Reports whether an access token issued by this platform is active, and its claims when it is.
+// The caller authenticates with a client_assertion as on the token endpoint and may only
+// introspect its own tokens; tokens of other clients are reported inactive.
+func (h *Handler) oauth2Introspect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "invalid form: "+err.Error())
+		return
+	}
+	token := r.Form.Get("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "missing token")
+		return
+	}
+	if h.validationRepo == nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "validation repository not configured")
+		return
+	}
+	client, authErr := h.authenticateClient(r)
+	if authErr != nil {
+		writeOAuthError(w, authErr.status, authErr.code, authErr.desc)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(h.introspectAccessToken(r.Context(), token, client.clientID))
+}
+
+// introspectAccessToken builds the RFC 7662 response for token as seen by callerClientID; anything
+// but a valid, recorded, unrevoked token issued to that client is {"active": false}.
+func (h *Handler) introspectAccessToken(ctx context.Context, token, callerClientID string) map[string]any {
+	inactive := map[string]any{"active": false}
+	set, err := keys.PublicKeySet()
+	if err != nil {
+		logger.Error("/api/oauth2/introspect: keys: %v", err)
+		return inactive
+	}
+	tok, err := jwt.ParseString(token, jwt.WithKeySet(set), jwt.WithValidate(true), jwt.WithAudience(h.issuer+"/api"))
+	if err != nil {
+		logger.Debug("/api/oauth2/introspect: invalid token: %v", err)
+		return inactive
+	}
+	t, err := h.validationRepo.GetIssuedToken(ctx, tok.JwtID())
+	if err != nil {
+		logger.Error("/api/oauth2/introspect: get issued token: %v", err)
+		return inactive
+	}
+	if t == nil || t.RevokedAt != nil || !time.Now().Before(t.ExpiresAt) {
+		logger.Debug("/api/oauth2/introspect: jti=%s is unknown, revoked or expired", tok.JwtID())
+		return inactive
+	}
+	if t.ClientID != callerClientID {
+		logger.Debug("/api/oauth2/introspect: jti=%s belongs to client_id=%s, not caller %s", t.JTI, t.ClientID, callerClientID)
+		return inactive
+	}
+	resp := map[string]any{
+		"active":     true,
+		"scope":      t.Scope,
+		"client_id":  t.ClientID,
+		"token_type": "Bearer",
+		"exp":        t.ExpiresAt.Unix(),
+		"iat":        t.IssuedAt.Unix(),
+		"sub":        tok.Subject(),
+		"aud":        tok.Audience(),
+		"iss":        tok.Issuer(),
+		"jti":        t.JTI,
+	}
+	if t.DeploymentID != "" {
+		resp[deploymentIDClaim] = t.DeploymentID
+	}
+	return resp
+}
+
+// oauth2Revoke POST /api/oauth2/revoke (RFC 7009)
+// Revokes an access token issued by this platform to the calling client, which authenticates with
+// a client_assertion as on the token endpoint. Unknown, invalid and already revoked tokens are
+// answered with 200 as well, as the RFC requires; tokens of other clients are refused.
+func (h *Handler) oauth2Revoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "invalid form: "+err.Error())
+		return
+	}
+	token := r.Form.Get("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "missing token")
+		return
+	}
+	if h.validationRepo == nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "validation repository not configured")
+		return
+	}
+	client, authErr := h.authenticateClient(r)
+	if authErr != nil {
+		writeOAuthError(w, authErr.status, authErr.code, authErr.desc)
+		return
+	}
+	set, err := keys.PublicKeySet()
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to load keys")
+		return
+	}
+	// Expired tokens may still be revoked, so only the signature is checked.
+	tok, err := jwt.ParseString(token, jwt.WithKeySet(set))
+	if err != nil {
+		logger.Debug("/api/oauth2/revoke: ignoring invalid token: %v", err)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	// RFC 7009 section 2.1: only the client the token was issued to may revoke it.
+	t, err := h.validationRepo.GetIssuedToken(r.Context(), tok.JwtID())
+	if err != nil {
+		logger.Error("/api/oauth2/revoke: %v", err)
+		writeOAuthError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "repository error")
+		return
+	}
+	if t == nil {
+		logger.Debug("/api/oauth2/revoke: ignoring unknown jti=%s", tok.JwtID())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if t.ClientID != client.clientID {
+		writeOAuthError(w, http.StatusBadRequest, "unauthorized_client", "token was not issued to this client")
+		return
+	}
+	ok, err := h.validationRepo.RevokeIssuedToken(r.Context(), t.JTI)
+	if err != nil {
+		logger.Error("/api/oauth2/revoke: %v", err)
+		writeOAuthError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "repository error")
+		return
+	}
+	logger.Debug("/api/oauth2/revoke: jti=%s revoked=%t", tok.JwtID(), ok)
+	w.WriteHeader(http.StatusOK)
+}
+
+// This is NOT LTI Spec. Admin endpoint to cut off a tool's service access at once; requires the admin token.
+// revokeToolTokens POST /api/tools/{id}/tokens/revoke
+func (h *Handler) revokeToolTokens(w http.ResponseWriter, r *http.Request) {
+	tool := h.toolFromPath(w, r)
+	if tool == nil {
+		return
+	}
+	if h.validationRepo == nil {
+		http.Error(w, "validation repository not configured", http.StatusInternalServerError)
+		return
+	}
+	n, err := h.validationRepo.RevokeClientTokens(r.Context(), tool.ClientID)
+	if err != nil {
+		logger.Error("revoke tokens of tool %d: %v", tool.ID, err)
+		http.Error(w, "failed to revoke tokens", http.StatusInternalServerError)
+		return
+	}
+	logger.Debug("revokeToolTokens: tool=%d client_id=%s revoked=%d", tool.ID, tool.ClientID, n)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"revoked": n})
+}
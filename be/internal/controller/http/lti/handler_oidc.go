@@ -7,16 +7,17 @@ import (
 	"html/template"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/lestrrat-go/jwx/v2/jwa"
-	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 	"github.com/quipper/poc/lti/be/pkg/common/keys"
 	"github.com/quipper/poc/lti/be/pkg/common/logger"
+	contextsRepo "github.com/quipper/poc/lti/be/pkg/repositories/contexts"
+	repoIface "github.com/quipper/poc/lti/be/pkg/repositories/lti"
 )
 
 // oidcAuth handles the tool's redirect to the platform authorization endpoint and issues an id_token.
@@ -51,11 +52,12 @@ func (h *Handler) oidcAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	logger.Info("Using correlation cookie lti_corr=%s", corrCookie.Value)
-	storedClientID, targetLinkUri, resourceLinkID, contextID, ok, err := h.validationRepo.ConsumeOIDCState(r.Context(), corrCookie.Value)
+	launch, ok, err := h.validationRepo.ConsumeOIDCState(r.Context(), corrCookie.Value)
 	if !ok || err != nil {
 		http.Error(w, "invalid or expired correlation state", http.StatusUnauthorized)
 		return
 	}
+	storedClientID, targetLinkUri, resourceLinkID, contextID := launch.ClientID, launch.TargetLinkURI, launch.ResourceLinkID, launch.ContextID
 	// No nonce comparison; we no longer persist nonce in validation repository
 	if targetLinkUri == "" && resourceLinkID != "" {
 		logger.Debug("oidcAuth: target_link_uri empty, have resource_link_id=%s (tool may resolve target via this)", resourceLinkID)
@@ -87,28 +89,37 @@ func (h *Handler) oidcAuth(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "unknown client_id", http.StatusBadRequest)
 		return
 	}
-	// Compare host+path of redirectURI with the allowed redirect for this client.
+	// Tools registered dynamically declare their redirect_uris; require an exact match.
+	// Otherwise compare host+path of redirectURI with the allowed redirect for this client.
 	// For deep linking, tools typically require redirect_uri == target_link_url (deep_link_launches).
 	// Fallback to auth_url if target_link_url is not set.
-	reqURI, err := url.Parse(redirectURI)
-	if err != nil {
-		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
-		return
-	}
+	if len(tool.RedirectURIs) > 0 {
+		if !containsString(tool.RedirectURIs, redirectURI) {
+			logger.Debug("oidcAuth: redirect_uri=%s not in registered redirect_uris=%v", redirectURI, tool.RedirectURIs)
+			http.Error(w, "redirect_uri not allowed for this client", http.StatusBadRequest)
+			return
+		}
+	} else {
+		reqURI, err := url.Parse(redirectURI)
+		if err != nil {
+			http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+			return
+		}
 
-	allowed := tool.TargetLaunchURL //as per spec, verify URL against target launch
-	if allowed == "" {
-		allowed = tool.AuthURL
-	}
-	allowedURI, err := url.Parse(allowed)
-	if err != nil {
-		http.Error(w, "server misconfig: tool redirect url invalid", http.StatusInternalServerError)
-		return
-	}
-	if reqURI.Scheme != allowedURI.Scheme || reqURI.Host != allowedURI.Host || reqURI.Path != allowedURI.Path {
-		logger.Debug("requri.scheme=%s alloweduri.scheme=%s requri.host=%s alloweduri.host=%s requri.path=%s alloweduri.path=%s", reqURI.Scheme, allowedURI.Scheme, reqURI.Host, allowedURI.Host, reqURI.Path, allowedURI.Path)
-		http.Error(w, "redirect_uri not allowed for this client", http.StatusBadRequest)
-		return
+		allowed := tool.TargetLaunchURL //as per spec, verify URL against target launch
+		if allowed == "" {
+			allowed = tool.AuthURL
+		}
+		allowedURI, err := url.Parse(allowed)
+		if err != nil {
+			http.Error(w, "server misconfig: tool redirect url invalid", http.StatusInternalServerError)
+			return
+		}
+		if reqURI.Scheme != allowedURI.Scheme || reqURI.Host != allowedURI.Host || reqURI.Path != allowedURI.Path {
+			logger.Debug("requri.scheme=%s alloweduri.scheme=%s requri.host=%s alloweduri.host=%s requri.path=%s alloweduri.path=%s", reqURI.Scheme, allowedURI.Scheme, reqURI.Host, allowedURI.Host, reqURI.Path, allowedURI.Path)
+			http.Error(w, "redirect_uri not allowed for this client", http.StatusBadRequest)
+			return
+		}
 	}
 
 	// Build LTI id_token claims (minimal set for PoC)
@@ -119,105 +130,159 @@ func (h *Handler) oidcAuth(w http.ResponseWriter, r *http.Request) {
 	// Decide message type: ResourceLink vs DeepLinking
 	msgType := "LtiResourceLinkRequest"
 	var extraClaims map[string]any
+	// A deep linking request opens a session; its data value is a signed token naming the session,
+	// which the response must echo (stored once the id_token is signed below).
+	var dlSession *repoIface.DeepLinkSession
 	if ltiMessageHint == "deep_linking" {
 		msgType = "LtiDeepLinkingRequest"
+		settings := deepLinkSettingsWithDefaults(launch.DeepLinking)
+		dlSession = &repoIface.DeepLinkSession{
+			ID:               uuid.NewString(),
+			ClientID:         clientID,
+			DeploymentID:     launch.DeploymentID,
+			ContextID:        contextID,
+			AcceptTypes:      settings.AcceptTypes,
+			AcceptMultiple:   settings.AcceptMultiple,
+			AcceptMediaTypes: settings.AcceptMediaTypes,
+			AcceptLineItem:   *settings.AcceptLineItem,
+			Status:           repoIface.DeepLinkSessionOpen,
+			ExpiresAt:        now.Add(deepLinkSessionTTL),
+		}
+		dlData, err := h.signDeepLinkData(dlSession)
+		if err != nil {
+			logger.Error("oidcAuth: sign deep linking data: %v", err)
+			http.Error(w, "failed to sign deep linking data", http.StatusInternalServerError)
+			return
+		}
 		extraClaims = map[string]any{
-			"https://purl.imsglobal.org/spec/lti-dl/claim/deep_linking_settings": map[string]any{
-				"deep_link_return_url":                 h.issuer + "/api/deeplink/return",
-				"data":                                 contextID,
-				"accept_types":                         []string{"ltiResourceLink"},
-				"accept_presentation_document_targets": []string{"iframe", "window"},
-				"accept_multiple":                      false,
-			},
+			dlSettingsClaim: h.deepLinkSettingsClaimValue(settings, dlData),
 		}
 	}
 
-	// Create JWT
-	// Determine subject and optional profile claims
-	subject := anonSub()
-	email := ""
-	fullName := ""
-	// given := ""
-	// family := ""
-	// Use login_hint as a stable subject if available
-	if lh := firstNonEmpty(r.Form.Get("login_hint"), r.URL.Query().Get("login_hint")); lh != "" {
-		subject = lh
-		if email == "" && strings.Contains(lh, "@") {
-			email = lh
-		}
-		// TODO: In real case this should be populated from LMS session/auth, resolving to user data
-		// For PoC, hardcode the LMS user; later, populate from your session/auth
-		fullName = "Haries Efrika"
-		// given = "Haries"
-		// family = "Efrika"
+	// Resolve the platform user bound to this launch in launchStart.
+	// login_hint is echoed by the tool and must refer to the same user.
+	user, err := h.lookupUser(r.Context(), launch.UserID)
+	if err != nil {
+		http.Error(w, "repository error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "launch is not bound to a platform user", http.StatusUnauthorized)
+		return
+	}
+	if lh := firstNonEmpty(r.Form.Get("login_hint"), r.URL.Query().Get("login_hint")); lh != "" && lh != user.ID {
+		logger.Debug("oidcAuth: login_hint=%s does not match launch user id=%s", lh, user.ID)
+		http.Error(w, "login_hint mismatch", http.StatusUnauthorized)
+		return
 	}
 
+	// Create JWT
 	builder := jwt.NewBuilder().
 		Issuer(iss).
-		Subject(subject).
+		Subject(user.ID).
 		Audience([]string{clientID}).
 		IssuedAt(now).
 		Expiration(exp).
 		Claim("nonce", nonce).
 		Claim("https://purl.imsglobal.org/spec/lti/claim/version", "1.3.0").
 		Claim("https://purl.imsglobal.org/spec/lti/claim/message_type", msgType).
-		Claim("https://purl.imsglobal.org/spec/lti/claim/deployment_id", "dev-deployment")
+		Claim(deploymentIDClaim, launch.DeploymentID)
 
-	// Roles are required by many tools (including ltijs). For PoC, default to Instructor.
-	roles := []string{
-		"http://purl.imsglobal.org/vocab/lis/v2/institution/person#Instructor",
+	// Context the launch happens in; launchStart only accepts known contexts.
+	var launchContext *contextsRepo.Context
+	if contextID != "" {
+		launchContext, err = h.contexts.GetContext(r.Context(), contextID)
+		if err != nil {
+			logger.Error("oidcAuth: get context %s: %v", contextID, err)
+			http.Error(w, "repository error", http.StatusInternalServerError)
+			return
+		}
+		if launchContext == nil {
+			http.Error(w, "unknown context_id", http.StatusBadRequest)
+			return
+		}
+		builder = builder.Claim(contextClaim, contextClaimValue(launchContext))
 	}
 
-	// POC Workaround
-	// TODO: Set proper roles based on userid/ email
-	if resourceLinkID != "" {
-		logger.Debug("resource_link_id is set: %s", resourceLinkID)
-		// Workaround for POC
-		// To differentiate between teacher (haries@efrika.net) and student (student@efrika.net)
-		// when we have target_link_uri cookie, means this is student access.
-		roles = []string{
-			"http://purl.imsglobal.org/vocab/lis/v2/institution/person#Student",
+	// Roles come from the user's roster membership in the launch context,
+	// combined with the user's institution roles per LTI_INSTITUTION_ROLES.
+	// A failed lookup must not skip the membership check, so only "not a member" launches without roles.
+	member, err := h.launchMember(r.Context(), contextID, user)
+	if err != nil {
+		logger.Error("oidcAuth: roster lookup failed for user=%s context=%s: %v", user.ID, contextID, err)
+		http.Error(w, "repository error", http.StatusInternalServerError)
+		return
+	}
+	var contextRoles []string
+	inactive := false
+	if member != nil {
+		if strings.EqualFold(member.Status, "Inactive") {
+			if !h.markInactive {
+				logger.Debug("oidcAuth: rejecting inactive member user=%s context=%s", user.ID, contextID)
+				http.Error(w, "membership is inactive", http.StatusForbidden)
+				return
+			}
+			inactive = true
 		}
-		fullName = "Student Efrika"
-		// given = "Student"
-		// family = "Efrika"
+		contextRoles = member.Roles
 	}
+	roles := h.roleMapper.Resolve(contextRoles, user.Roles, member != nil)
 	// this claim is important to tell Tool what Content Item to launch
 	builder = builder.Claim("https://purl.imsglobal.org/spec/lti/claim/target_link_uri", targetLinkUri)
 	builder = builder.Claim("https://purl.imsglobal.org/spec/lti/claim/roles", roles)
 
 	// Optional user profile claims
-	if fullName != "" {
+	if fullName := user.FullName(); fullName != "" {
 		builder = builder.Claim("name", fullName)
 	}
-	// if given != "" {
-	// 	builder = builder.Claim("given_name", given)
-	// }
-	// if family != "" {
-	// 	builder = builder.Claim("family_name", family)
-	// }
-	if email != "" {
-		builder = builder.Claim("email", email)
+	if user.GivenName != "" {
+		builder = builder.Claim("given_name", user.GivenName)
+	}
+	if user.FamilyName != "" {
+		builder = builder.Claim("family_name", user.FamilyName)
+	}
+	if user.Email != "" {
+		builder = builder.Claim("email", user.Email)
 	}
 
 	// For a resource launch, include a resource_link claim
+	var link *repoIface.ResourceLink
 	if msgType == "LtiResourceLinkRequest" {
-		builder = builder.Claim("https://purl.imsglobal.org/spec/lti/claim/resource_link", map[string]any{
-			"id": resourceLinkID,
-		})
-
-		// Add AGS endpoint claim with context-scoped lineitems URL and allowed scopes
-		// contextID was stored during launchStart and retrieved from validation state.
-		if contextID == "" {
-			logger.Debug("oidcAuth: missing context_id in state; falling back to dev-context")
-			contextID = "dev-context"
+		// Launches without a stored link (e.g. straight to target_link_uri) still carry the bare id.
+		link, err = h.repo.GetResourceLink(r.Context(), resourceLinkID)
+		if err != nil {
+			logger.Debug("oidcAuth: failed to get resource link %s: %v", resourceLinkID, err)
+		}
+		if link != nil {
+			builder = builder.Claim(resourceLinkClaim, resourceLinkClaimValue(link))
 		} else {
-			logger.Debug("oidcAuth: using context_id=%s", contextID)
+			builder = builder.Claim(resourceLinkClaim, map[string]any{
+				"id": resourceLinkID,
+			})
 		}
-		base := h.issuer
-		if pub := os.Getenv("PUBLIC_BASE_URL"); pub != "" {
-			base = pub
+	}
+
+	// Custom parameters from the tool, deployment and resource link, with substitution variables expanded.
+	custom, err := h.launchCustom(r.Context(), tool, launch.DeploymentID, link, launchCustomVars(user, launchContext, link, roles))
+	if err != nil {
+		logger.Debug("oidcAuth: failed to load deployment custom parameters: %v", err)
+	}
+	if inactive {
+		if custom == nil {
+			custom = map[string]string{}
 		}
+		custom[membershipStatusCustomKey] = "Inactive"
+	}
+	if len(custom) > 0 {
+		builder = builder.Claim(customClaim, custom)
+	}
+
+	// Services are context-scoped, so launches outside a context advertise none.
+	if msgType == "LtiResourceLinkRequest" && contextID != "" {
+		// Add AGS endpoint claim with context-scoped lineitems URL and allowed scopes
+		// contextID was stored during launchStart and retrieved from validation state.
+		logger.Debug("oidcAuth: using context_id=%s", contextID)
+		base := h.publicBaseURL()
 
 		// Resolve line item id from resourceLinkID using repository reverse lookup
 		lineItemId := ""
@@ -230,50 +295,43 @@ func (h *Handler) oidcAuth(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		agsClaim := map[string]any{
-			// Tool can only access the lineItemId we specified/ linked to resourceLinkId.
-			"lineitem":  base + "/api/ags/contexts/" + contextID + "/lineitems/" + lineItemId,
-			"lineitems": base + "/api/ags/contexts/" + contextID + "/lineitems",
-			"scope": []string{
-				"https://purl.imsglobal.org/spec/lti-ags/scope/lineitem.readonly",
-				// TODO: In production, scope lineitem (write/del) below must be removed,
-				// so that Tool can't messly delete the lineitem that we already tightly coupled with resourceLinkID.
-				// LMS should manage the final state of lineitem/gradebook, not the Tool.
-				"https://purl.imsglobal.org/spec/lti-ags/scope/lineitem", // <-- to be removed
-				"https://purl.imsglobal.org/spec/lti-ags/scope/result.readonly",
-				"https://purl.imsglobal.org/spec/lti-ags/scope/score",
-			},
+		// Only the service scopes the tool is allowed are advertised; a service with none is left out.
+		agsScopes := filterScopes(tool.AllowedScopes, repoIface.ScopeLineItemReadonly, repoIface.ScopeLineItem, repoIface.ScopeResultReadonly, repoIface.ScopeScore)
+		nrpsScopes := filterScopes(tool.AllowedScopes, repoIface.ScopeContextMembershipReadonly)
+		var services []map[string]any
+		if len(agsScopes) > 0 {
+			agsClaim := map[string]any{
+				// Tool can only access the lineItemId we specified/ linked to resourceLinkId.
+				"lineitem":  base + "/api/ags/contexts/" + contextID + "/lineitems/" + lineItemId,
+				"lineitems": base + "/api/ags/contexts/" + contextID + "/lineitems",
+				"scope":     agsScopes,
+			}
+			logger.Debug("OIDC id_token AGS claim: %+v", agsClaim)
+			builder = builder.Claim("https://purl.imsglobal.org/spec/lti-ags/claim/endpoint", agsClaim)
+			// Also advertise token endpoint via LTI Services claim so tools know where to obtain an access token
+			services = append(services, map[string]any{
+				"endpoint": base + "/oauth2/token",
+				"scope":    agsScopes,
+			})
 		}
-		logger.Debug("OIDC id_token AGS claim: %+v", agsClaim)
-		builder = builder.Claim("https://purl.imsglobal.org/spec/lti-ags/claim/endpoint", agsClaim)
 
-		// NRPS claim: advertise context memberships endpoint and version
-		nrpsClaim := map[string]any{
-			"context_memberships_url": base + "/api/nrps/contexts/" + contextID + "/members",
-			"service_versions":        []string{"2.0"},
-		}
-		logger.Debug("OIDC id_token NRPS claim: %+v", nrpsClaim)
-		builder = builder.Claim("https://purl.imsglobal.org/spec/lti-nrps/claim/namesroleservice", nrpsClaim)
-		// Also advertise token endpoint via LTI Services claim so tools know where to obtain an access token
-		services := []map[string]any{
-			{
-				"endpoint": base + "/oauth2/token",
-				"scope": []string{
-					"https://purl.imsglobal.org/spec/lti-ags/scope/lineitem.readonly",
-					"https://purl.imsglobal.org/spec/lti-ags/scope/lineitem",
-					"https://purl.imsglobal.org/spec/lti-ags/scope/result.readonly",
-					"https://purl.imsglobal.org/spec/lti-ags/scope/score",
-				},
-			},
-			{
+		if len(nrpsScopes) > 0 {
+			// NRPS claim: advertise context memberships endpoint and version
+			nrpsClaim := map[string]any{
+				"context_memberships_url": base + "/api/nrps/contexts/" + contextID + "/members",
+				"service_versions":        []string{"2.0"},
+			}
+			logger.Debug("OIDC id_token NRPS claim: %+v", nrpsClaim)
+			builder = builder.Claim("https://purl.imsglobal.org/spec/lti-nrps/claim/namesroleservice", nrpsClaim)
+			services = append(services, map[string]any{
 				"endpoint": base + "/api/nrps/contexts/" + contextID + "/members",
-				"scope": []string{
-					"https://purl.imsglobal.org/spec/lti-nrps/scope/contextmembership.readonly",
-				},
-			},
+				"scope":    nrpsScopes,
+			})
+		}
+		if len(services) > 0 {
+			logger.Debug("OIDC id_token Services claim: %+v", services)
+			builder = builder.Claim("https://purl.imsglobal.org/spec/lti/claim/service", services)
 		}
-		logger.Debug("OIDC id_token Services claim: %+v", services)
-		builder = builder.Claim("https://purl.imsglobal.org/spec/lti/claim/service", services)
 	}
 
 	for k, v := range extraClaims {
@@ -287,30 +345,28 @@ func (h *Handler) oidcAuth(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Ensure keys are initialized
-	if err := keys.Init(); err != nil {
-		http.Error(w, "failed to initialize keys", http.StatusInternalServerError)
-		return
-	}
-	// Prepare signing key (after keys.Init())
-	priv := keys.PrivateKey()
-	if priv == nil {
-		http.Error(w, "signing key not initialized", http.StatusInternalServerError)
-		return
-	}
-	key, err := jwk.FromRaw(priv)
+	// Sign with the platform's active key for the algorithm this tool accepts (kid is carried in the header)
+	key, err := keys.SigningKey(jwa.SignatureAlgorithm(tool.IDTokenAlg))
 	if err != nil {
+		logger.Error("oidcAuth: signing key for tool %s (alg=%q): %v", tool.ClientID, tool.IDTokenAlg, err)
 		http.Error(w, "failed to load signing key", http.StatusInternalServerError)
 		return
 	}
-	_ = key.Set(jwk.KeyIDKey, keys.Kid())
-	_ = key.Set(jwk.AlgorithmKey, jwa.RS256)
-
-	signed, err := jwt.Sign(tok, jwt.WithKey(jwa.RS256, key))
+	signed, err := jwt.Sign(tok, jwt.WithKey(key.Algorithm(), key))
 	if err != nil {
 		http.Error(w, "failed to sign id_token", http.StatusInternalServerError)
 		return
 	}
+	// The deep linking session is only stored once the request can be sent, so every failure
+	// above leaves no open session behind.
+	if dlSession != nil {
+		dlSession.UserID = user.ID
+		if err := h.repo.CreateDeepLinkSession(r.Context(), dlSession); err != nil {
+			logger.Error("oidcAuth: store deep linking session: %v", err)
+			http.Error(w, "failed to store deep linking session", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	// Return form_post
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
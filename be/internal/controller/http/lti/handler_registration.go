@@ -0,0 +1,267 @@
+package lti
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/quipper/poc/lti/be/pkg/common/keys"
+	"github.com/quipper/poc/lti/be/pkg/common/logger"
+	repoIface "github.com/quipper/poc/lti/be/pkg/repositories/lti"
+)
+
+const ltiToolConfigurationClaim = "https://purl.imsglobal.org/spec/lti-tool-configuration"
+
+// registrationTokenTTL bounds how long a tool may take to complete Dynamic Registration.
+const registrationTokenTTL = 1 * time.Hour
+
+// ltiMessage is a message the tool supports, per LTI Dynamic Registration.
+type ltiMessage struct {
+	Type          string `json:"type"`
+	TargetLinkURI string `json:"target_link_uri,omitempty"`
+	Label         string `json:"label,omitempty"`
+}
+
+// ltiToolConfiguration is the https://purl.imsglobal.org/spec/lti-tool-configuration object.
+type ltiToolConfiguration struct {
+	Domain           string            `json:"domain"`
+	SecondaryDomains []string          `json:"secondary_domains,omitempty"`
+	DeploymentID     string            `json:"deployment_id,omitempty"`
+	TargetLinkURI    string            `json:"target_link_uri"`
+	CustomParameters map[string]string `json:"custom_parameters,omitempty"`
+	Description      string            `json:"description,omitempty"`
+	Messages         []ltiMessage      `json:"messages,omitempty"`
+	Claims           []string          `json:"claims,omitempty"`
+}
+
+// clientRegistration is the OpenID Connect client metadata a tool posts during Dynamic Registration.
+type clientRegistration struct {
+	ClientID                 string               `json:"client_id,omitempty"`
+	ApplicationType          string               `json:"application_type"`
+	GrantTypes               []string             `json:"grant_types"`
+	ResponseTypes            []string             `json:"response_types"`
+	RedirectURIs             []string             `json:"redirect_uris"`
+	InitiateLoginURI         string               `json:"initiate_login_uri"`
+	ClientName               string               `json:"client_name"`
+	JWKSURI                  string               `json:"jwks_uri"`
+	LogoURI                  string               `json:"logo_uri,omitempty"`
+	TokenEndpointAuthMethod  string               `json:"token_endpoint_auth_method"`
+	IDTokenSignedResponseAlg string               `json:"id_token_signed_response_alg,omitempty"`
+	Contacts                 []string             `json:"contacts,omitempty"`
+	Scope                    string               `json:"scope,omitempty"`
+	ToolConfiguration        ltiToolConfiguration `json:"https://purl.imsglobal.org/spec/lti-tool-configuration"`
+}
+
+// registrationStart begins LTI Dynamic Registration by redirecting the user-agent to the
+// tool's registration initiation URL with openid_configuration and a one-time registration_token.
+// GET/POST /api/registration/start?registration_url=...
+func (h *Handler) registrationStart(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	regURL := r.FormValue("registration_url")
+	logger.Debug("registrationStart: registration_url=%s", regURL)
+	if regURL == "" {
+		http.Error(w, "missing registration_url", http.StatusBadRequest)
+		return
+	}
+	u, err := url.Parse(regURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		http.Error(w, "invalid registration_url", http.StatusBadRequest)
+		return
+	}
+	if h.validationRepo == nil {
+		http.Error(w, "validation repository not configured", http.StatusInternalServerError)
+		return
+	}
+	token := anonSub()
+	if err := h.validationRepo.CreateRegistrationToken(r.Context(), token, time.Now().Add(registrationTokenTTL)); err != nil {
+		logger.Debug("registrationStart: failed to create registration token: %v", err)
+		http.Error(w, "failed to create registration token", http.StatusInternalServerError)
+		return
+	}
+	q := u.Query()
+	q.Set("openid_configuration", h.publicBaseURL()+"/.well-known/openid-configuration")
+	q.Set("registration_token", token)
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+	logger.Debug("registrationStart: redirected to %s", u.String())
+}
+
+// registrationCreate is the Dynamic Registration client registration endpoint.
+// The tool posts its OpenID client metadata with the registration_token as Bearer;
+// the platform creates the Tool and returns the metadata with client_id and deployment_id.
+// POST /api/registration
+func (h *Handler) registrationCreate(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if auth == "" || !strings.HasPrefix(strings.ToLower(auth), "bearer ") {
+		writeRegistrationError(w, http.StatusUnauthorized, "invalid_token", "missing registration token")
+		return
+	}
+	token := strings.TrimSpace(auth[len("Bearer "):])
+	if h.validationRepo == nil {
+		writeRegistrationError(w, http.StatusInternalServerError, "server_error", "validation repository not configured")
+		return
+	}
+
+	// Metadata is validated before the one-time token is consumed, so a tool can fix and retry.
+	var reg clientRegistration
+	if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+		writeRegistrationError(w, http.StatusBadRequest, "invalid_client_metadata", "invalid JSON body")
+		return
+	}
+	if desc := validateClientRegistration(&reg); desc != "" {
+		writeRegistrationError(w, http.StatusBadRequest, "invalid_client_metadata", desc)
+		return
+	}
+	if reg.IDTokenSignedResponseAlg != "" && !keys.IsEnabled(reg.IDTokenSignedResponseAlg) {
+		writeRegistrationError(w, http.StatusBadRequest, "invalid_client_metadata", "id_token_signed_response_alg must be one of: "+strings.Join(keys.EnabledAlgs(), ", "))
+		return
+	}
+	ok, err := h.validationRepo.ConsumeRegistrationToken(r.Context(), token)
+	if err != nil {
+		writeRegistrationError(w, http.StatusInternalServerError, "server_error", "repository error")
+		return
+	}
+	if !ok {
+		writeRegistrationError(w, http.StatusUnauthorized, "invalid_token", "invalid, expired or already used registration token")
+		return
+	}
+
+	cfg := reg.ToolConfiguration
+	deepLinkURL := cfg.TargetLinkURI
+	for _, m := range cfg.Messages {
+		if m.Type == "LtiDeepLinkingRequest" && m.TargetLinkURI != "" {
+			deepLinkURL = m.TargetLinkURI
+		}
+	}
+	name := reg.ClientName
+	if name == "" {
+		name = cfg.Domain
+	}
+	// Only read-only scopes are granted on registration; lineitem and score need an admin grant.
+	tool := repoIface.Tool{
+		Name:            name,
+		ClientID:        uuid.NewString(),
+		AuthURL:         reg.InitiateLoginURI,
+		TargetLinkURL:   deepLinkURL,
+		TargetLaunchURL: cfg.TargetLinkURI,
+		KeySetURL:       reg.JWKSURI,
+		IDTokenAlg:      reg.IDTokenSignedResponseAlg,
+		RedirectURIs:    reg.RedirectURIs,
+		Custom:          cfg.CustomParameters,
+		AllowedScopes:   filterScopes(grantableScopes(reg.Scope), repoIface.ReadonlyServiceScopes...),
+	}
+	// The tool and its deployment are created together, so the tool is never left undeployed.
+	dep := defaultDeployment(0)
+	if err := h.repo.RegisterToolWithDeployment(r.Context(), &tool, dep); err != nil {
+		logger.Error("dynamic registration: register tool: %v", err)
+		writeRegistrationError(w, http.StatusInternalServerError, "server_error", "failed to register tool")
+		return
+	}
+	logger.Info("dynamic registration: created tool id=%d name=%s client_id=%s deployment_id=%s", tool.ID, tool.Name, tool.ClientID, dep.DeploymentID)
+
+	// Echo the registered metadata back with platform-assigned values.
+	reg.ClientID = tool.ClientID
+	reg.Scope = strings.Join(tool.AllowedScopes, " ")
+	if reg.IDTokenSignedResponseAlg == "" {
+		reg.IDTokenSignedResponseAlg = keys.DefaultAlg().String()
+	}
+	reg.ToolConfiguration.DeploymentID = dep.DeploymentID
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(reg)
+}
+
+// validateClientRegistration checks the metadata required by LTI Dynamic Registration.
+// Returns an error description, or "" when valid.
+func validateClientRegistration(reg *clientRegistration) string {
+	if reg.ApplicationType != "" && reg.ApplicationType != "web" {
+		return "application_type must be web"
+	}
+	if !containsString(reg.ResponseTypes, "id_token") {
+		return "response_types must include id_token"
+	}
+	if !containsString(reg.GrantTypes, "implicit") || !containsString(reg.GrantTypes, "client_credentials") {
+		return "grant_types must include implicit and client_credentials"
+	}
+	if reg.TokenEndpointAuthMethod != "private_key_jwt" {
+		return "token_endpoint_auth_method must be private_key_jwt"
+	}
+	if reg.InitiateLoginURI == "" {
+		return "initiate_login_uri is required"
+	}
+	if len(reg.RedirectURIs) == 0 {
+		return "redirect_uris is required"
+	}
+	if reg.JWKSURI == "" {
+		return "jwks_uri is required"
+	}
+	for _, raw := range append([]string{reg.InitiateLoginURI, reg.JWKSURI}, reg.RedirectURIs...) {
+		if u, err := url.Parse(raw); err != nil || u.Scheme == "" || u.Host == "" {
+			return "invalid URL: " + raw
+		}
+	}
+	cfg := reg.ToolConfiguration
+	if cfg.Domain == "" {
+		return ltiToolConfigurationClaim + ".domain is required"
+	}
+	if cfg.TargetLinkURI == "" {
+		return ltiToolConfigurationClaim + ".target_link_uri is required"
+	}
+	return ""
+}
+
+// grantableScopes returns the requested scopes this platform supports.
+func grantableScopes(requested string) []string {
+	out := []string{}
+	for _, s := range strings.Fields(requested) {
+		if containsString(repoIface.ServiceScopes, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// allowedScopes returns the requested scopes the tool may be granted, in request order.
+func allowedScopes(tool *repoIface.Tool, requested string) []string {
+	out := []string{}
+	for _, s := range grantableScopes(requested) {
+		if containsString(tool.AllowedScopes, s) && !containsString(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// filterScopes returns the scopes of want that are in allowed, in the order of want.
+func filterScopes(allowed []string, want ...string) []string {
+	var out []string
+	for _, s := range want {
+		if containsString(allowed, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func containsString(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// writeRegistrationError writes an RFC 7591 style error response.
+func writeRegistrationError(w http.ResponseWriter, status int, code, desc string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error":             code,
+		"error_description": desc,
+	})
+	logger.Debug("/api/registration error: status=%d error=%s desc=%s", status, code, desc)
+}
@@ -0,0 +1,235 @@
+package lti
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/quipper/poc/lti/be/pkg/common/logger"
+	repoIface "github.com/quipper/poc/lti/be/pkg/repositories/lti"
+)
+
+const (
+	resourceLinkClaim = "https://purl.imsglobal.org/spec/lti/claim/resource_link"
+	customClaim       = "https://purl.imsglobal.org/spec/lti/claim/custom"
+)
+
+// resourceLinkClaimValue builds the LTI resource_link claim for a launch.
+func resourceLinkClaimValue(l *repoIface.ResourceLink) map[string]any {
+	claim := map[string]any{"id": l.ID}
+	if l.Title != "" {
+		claim["title"] = l.Title
+	}
+	if l.Description != "" {
+		claim["description"] = l.Description
+	}
+	return claim
+}
+
+// customParams converts a content item's custom object into string parameters.
+// Non-string values are kept as their JSON encoding.
+func customParams(raw any) map[string]string {
+	m, ok := raw.(map[string]any)
+	if !ok || len(m) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		if s, ok := v.(string); ok {
+			out[k] = s
+			continue
+		}
+		if b, err := json.Marshal(v); err == nil {
+			out[k] = string(b)
+		}
+	}
+	return out
+}
+
+// itemWindow reads a content item {"startDateTime", "endDateTime"} window; unparseable bounds are nil.
+func itemWindow(raw any) *repoIface.TimeWindow {
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return nil
+	}
+	parse := func(key string) *time.Time {
+		s, _ := m[key].(string)
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil
+		}
+		return &t
+	}
+	w := &repoIface.TimeWindow{StartDateTime: parse("startDateTime"), EndDateTime: parse("endDateTime")}
+	if w.StartDateTime == nil && w.EndDateTime == nil {
+		return nil
+	}
+	return w
+}
+
+// resourceLinkFromItem builds the resource link for an ltiResourceLink content item of tool in
+// contextID, with a new ID so line items can be mapped to it before it is stored.
+func resourceLinkFromItem(tool *repoIface.Tool, deploymentID, contextID string, item *repoIface.ContentItem) *repoIface.ResourceLink {
+	l := &repoIface.ResourceLink{
+		ID:           uuid.NewString(),
+		ContextID:    contextID,
+		ToolID:       tool.ID,
+		DeploymentID: deploymentID,
+		Title:        item.Title,
+		Description:  item.Text,
+		URL:          item.URL,
+		Custom:       item.Custom,
+	}
+	if w := item.Available; w != nil {
+		l.AvailableStart, l.AvailableEnd = w.StartDateTime, w.EndDateTime
+	}
+	if w := item.Submission; w != nil {
+		l.SubmissionStart, l.SubmissionEnd = w.StartDateTime, w.EndDateTime
+	}
+	return l
+}
+
+// This is NOT LTI Spec. Admin endpoints to manage resource links (tool placements in a context).
+// listResourceLinks GET /api/resource-links?context_id=
+func (h *Handler) listResourceLinks(w http.ResponseWriter, r *http.Request) {
+	items, err := h.repo.ListResourceLinks(r.Context(), r.URL.Query().Get("context_id"))
+	if err != nil {
+		logger.Error("list resource links: %v", err)
+		http.Error(w, "failed to list resource links", http.StatusInternalServerError)
+		return
+	}
+	if items == nil {
+		items = []*repoIface.ResourceLink{}
+	}
+	logger.Debug("listResourceLinks: returned %d items", len(items))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(items)
+}
+
+// createResourceLink POST /api/resource-links
+// body: {"id" (optional), "context_id", "tool_id" | "client_id", "deployment_id" (optional), "title", "description", "url", "custom",
+// "available_start", "available_end", "submission_start", "submission_end"}
+func (h *Handler) createResourceLink(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		repoIface.ResourceLink
+		ClientID string `json:"client_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	l := req.ResourceLink
+	l.ID = strings.TrimSpace(l.ID)
+	if l.ContextID == "" {
+		http.Error(w, "context_id is required", http.StatusBadRequest)
+		return
+	}
+	c, err := h.contexts.GetContext(r.Context(), l.ContextID)
+	if err != nil {
+		logger.Error("get context %s: %v", l.ContextID, err)
+		http.Error(w, "failed to create resource link", http.StatusInternalServerError)
+		return
+	}
+	if c == nil {
+		http.Error(w, "unknown context_id", http.StatusBadRequest)
+		return
+	}
+	var tool *repoIface.Tool
+	if req.ClientID != "" {
+		tool, err = h.repo.GetToolByClientID(r.Context(), req.ClientID)
+	} else {
+		tool, err = h.repo.GetToolByID(r.Context(), l.ToolID)
+	}
+	if err != nil {
+		logger.Error("get tool: %v", err)
+		http.Error(w, "failed to create resource link", http.StatusInternalServerError)
+		return
+	}
+	if tool == nil {
+		http.Error(w, "unknown tool", http.StatusBadRequest)
+		return
+	}
+	l.ToolID = tool.ID
+	d, err := h.launchDeployment(r.Context(), tool, l.DeploymentID, l.ContextID)
+	if err != nil {
+		logger.Error("resolve deployment: %v", err)
+		http.Error(w, "failed to create resource link", http.StatusInternalServerError)
+		return
+	}
+	if d == nil {
+		http.Error(w, "tool is not deployed for this context", http.StatusBadRequest)
+		return
+	}
+	l.DeploymentID = d.DeploymentID
+	if l.ID != "" {
+		if existing, err := h.repo.GetResourceLink(r.Context(), l.ID); err != nil {
+			logger.Error("get resource link %s: %v", l.ID, err)
+			http.Error(w, "failed to create resource link", http.StatusInternalServerError)
+			return
+		} else if existing != nil {
+			http.Error(w, "resource link already exists", http.StatusConflict)
+			return
+		}
+	}
+	if err := h.repo.CreateResourceLink(r.Context(), &l); err != nil {
+		logger.Error("create resource link: %v", err)
+		http.Error(w, "failed to create resource link", http.StatusInternalServerError)
+		return
+	}
+	logger.Debug("createResourceLink: created id=%s tool=%d context=%s deployment=%s", l.ID, l.ToolID, l.ContextID, l.DeploymentID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(l)
+}
+
+// getResourceLink GET /api/resource-links/{id}
+func (h *Handler) getResourceLink(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	l, err := h.repo.GetResourceLink(r.Context(), id)
+	if err != nil {
+		logger.Error("get resource link %s: %v", id, err)
+		http.Error(w, "failed to get resource link", http.StatusInternalServerError)
+		return
+	}
+	if l == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(l)
+}
+
+// updateResourceLink PUT /api/resource-links/{id}  replaces title, description, url, custom and the availability windows.
+func (h *Handler) updateResourceLink(w http.ResponseWriter, r *http.Request) {
+	var req repoIface.ResourceLink
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	req.ID = chi.URLParam(r, "id")
+	ok, err := h.repo.UpdateResourceLink(r.Context(), &req)
+	if err != nil {
+		logger.Error("update resource link %s: %v", req.ID, err)
+		http.Error(w, "failed to update resource link", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	h.getResourceLink(w, r)
+}
+
+// deleteResourceLink DELETE /api/resource-links/{id}
+func (h *Handler) deleteResourceLink(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := h.repo.DeleteResourceLink(r.Context(), id); err != nil {
+		logger.Error("delete resource link %s: %v", id, err)
+		http.Error(w, "failed to delete resource link", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
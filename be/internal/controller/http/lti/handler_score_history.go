@@ -0,0 +1,52 @@
+package lti
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/quipper/poc/lti/be/pkg/common/logger"
+	scoresRepo "github.com/quipper/poc/lti/be/pkg/repositories/scores"
+)
+
+// This is NOT LTI Spec. Admin endpoint for instructors to review regrades and tool resubmissions.
+// scoreHistory GET /api/contexts/{id}/lineitems/{lineItemId}/scores/{userId}
+// Lists every score received for the user on the line item, oldest first, with the submitting
+// tool's client_id. Query: limit (default 50), offset; Link rel="next" if more pages.
+func (h *Handler) scoreHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	contextID := chi.URLParam(r, "id")
+	userID := chi.URLParam(r, "userId")
+	id, err := strconv.ParseInt(chi.URLParam(r, "lineItemId"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid lineItemId", http.StatusBadRequest)
+		return
+	}
+	li, err := h.scores.GetLineItem(ctx, id, contextID)
+	if err != nil {
+		logger.Error("score history: get lineitem %d: %v", id, err)
+		http.Error(w, "failed to get line item", http.StatusInternalServerError)
+		return
+	}
+	if li == nil {
+		http.NotFound(w, r)
+		return
+	}
+	offset, limit := pageParams(r)
+	history, total, err := h.scores.ListScoreHistory(ctx, id, contextID, userID, offset, limit)
+	if err != nil {
+		logger.Error("score history: lineitem %d user %s: %v", id, userID, err)
+		http.Error(w, "failed to list scores", http.StatusInternalServerError)
+		return
+	}
+	if history == nil {
+		history = []*scoresRepo.ScoreRecord{}
+	}
+	if offset+limit < total {
+		w.Header().Add("Link", "<"+buildPageURL(r, offset+limit, limit)+">; rel=\"next\"")
+	}
+	logger.Debug("score history: lineitem=%d user=%s returned=%d total=%d", id, userID, len(history), total)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(history)
+}
@@ -7,6 +7,7 @@ import (
 
     "github.com/go-chi/chi/v5"
     "github.com/quipper/poc/lti/be/pkg/common/logger"
+    repoIface "github.com/quipper/poc/lti/be/pkg/repositories/lti"
 )
 
 func (h *Handler) listSelections(w http.ResponseWriter, r *http.Request) {
@@ -25,6 +26,9 @@ func (h *Handler) listSelections(w http.ResponseWriter, r *http.Request) {
         logger.Debug("listSelections: returned empty list")
         return
     }
+    for _, sel := range sels {
+        fillSelectionItem(sel)
+    }
     logger.Debug("listSelections: returned %d items", len(sels))
     _ = json.NewEncoder(w).Encode(sels)
 }
@@ -49,10 +53,22 @@ func (h *Handler) getSelectionByID(w http.ResponseWriter, r *http.Request) {
         http.NotFound(w, r)
         return
     }
+    fillSelectionItem(sel)
     w.Header().Set("Content-Type", "application/json")
     _ = json.NewEncoder(w).Encode(sel)
 }
 
+// fillSelectionItem derives the typed item of selections stored before items were typed.
+func fillSelectionItem(sel *repoIface.DeepLinkSelection) {
+    if sel.Item != nil {
+        return
+    }
+    sel.Item = contentItemFromJSON(sel.ContentItemJSON)
+    if sel.Item != nil && sel.Type == "" {
+        sel.Type = sel.Item.Type
+    }
+}
+
 func (h *Handler) deleteSelectionByID(w http.ResponseWriter, r *http.Request) {
     idStr := chi.URLParam(r, "id")
     id, err := strconv.ParseInt(idStr, 10, 64)
@@ -0,0 +1,35 @@
+package lti
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUpdateToolScopesRequiresAdmin(t *testing.T) {
+	h := &Handler{adminToken: "s3cret"}
+	body := `{"allowed_scopes": ["https://purl.imsglobal.org/spec/lti-ags/scope/score"]}`
+	for _, auth := range []string{"", "Bearer wrong"} {
+		req := httptest.NewRequest(http.MethodPut, "/api/tools/1/scopes", strings.NewReader(body))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status = %d, want %d", auth, rec.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestCreateToolAllowedScopesRequiresAdmin(t *testing.T) {
+	h := &Handler{adminToken: "s3cret"}
+	body := `{"name": "t", "client_id": "c", "allowed_scopes": ["https://purl.imsglobal.org/spec/lti-ags/scope/score"]}`
+	req := httptest.NewRequest(http.MethodPost, "/api/tools", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.Router().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
@@ -7,6 +7,7 @@ import (
     "strings"
 
     repoIface "github.com/quipper/poc/lti/be/pkg/repositories/lti"
+    "github.com/quipper/poc/lti/be/pkg/common/keys"
     "github.com/quipper/poc/lti/be/pkg/common/logger"
     "github.com/go-chi/chi/v5"
 )
@@ -20,22 +21,46 @@ func (h *Handler) createTool(w http.ResponseWriter, r *http.Request) {
         http.Error(w, "invalid JSON body", http.StatusBadRequest)
         return
     }
+    // Granting scopes is an admin action, like PUT /api/tools/{id}/scopes.
+    if req.AllowedScopes != nil && !h.isAdmin(r) {
+        logger.Debug("createTool: allowed_scopes without admin token")
+        w.Header().Set("WWW-Authenticate", "Bearer")
+        http.Error(w, "allowed_scopes requires the admin token", http.StatusUnauthorized)
+        return
+    }
     // Minimal validation
     if strings.TrimSpace(req.Name) == "" || strings.TrimSpace(req.ClientID) == "" {
         logger.Debug("createTool: missing name/client_id")
         http.Error(w, "name and client_id are required", http.StatusBadRequest)
         return
     }
-    id, err := h.repo.RegisterTool(r.Context(), &req)
-    if err != nil {
+    if req.IDTokenAlg != "" && !keys.IsEnabled(req.IDTokenAlg) {
+        logger.Debug("createTool: unsupported id_token alg=%s", req.IDTokenAlg)
+        http.Error(w, "id_token_signed_response_alg must be one of: "+strings.Join(keys.EnabledAlgs(), ", "), http.StatusBadRequest)
+        return
+    }
+    // Tools created without allowed_scopes only get the read-only service scopes;
+    // lineitem and score must be granted explicitly.
+    if req.AllowedScopes == nil {
+        req.AllowedScopes = repoIface.ReadonlyServiceScopes
+    }
+    if s := unsupportedScope(req.AllowedScopes); s != "" {
+        logger.Debug("createTool: unsupported scope=%s", s)
+        http.Error(w, "unsupported scope in allowed_scopes: "+s, http.StatusBadRequest)
+        return
+    }
+    // The tool and its default deployment are created together.
+    dep := defaultDeployment(0)
+    if err := h.repo.RegisterToolWithDeployment(r.Context(), &req, dep); err != nil {
         logger.Error("register tool: %v", err)
         http.Error(w, "failed to register tool", http.StatusInternalServerError)
         return
     }
-    logger.Debug("createTool: created id=%d name=%s client_id=%s", id, req.Name, req.ClientID)
+    logger.Debug("createTool: created id=%d name=%s client_id=%s deployment_id=%s", req.ID, req.Name, req.ClientID, dep.DeploymentID)
     _ = json.NewEncoder(w).Encode(map[string]any{
-        "id":        id,
-        "created_at": req.CreatedAt,
+        "id":            req.ID,
+        "deployment_id": dep.DeploymentID,
+        "created_at":    req.CreatedAt,
     })
 }
 
@@ -101,3 +126,53 @@ func (h *Handler) getToolByIDChi(w http.ResponseWriter, r *http.Request) {
     logger.Debug("getToolByIDChi: found id=%d name=%s", id, item.Name)
     _ = json.NewEncoder(w).Encode(item)
 }
+
+// updateToolScopes PUT /api/tools/{id}/scopes
+// Replaces the service scopes the tool may be granted: {"allowed_scopes": [...]}. Requires the admin token.
+func (h *Handler) updateToolScopes(w http.ResponseWriter, r *http.Request) {
+    tool := h.toolFromPath(w, r)
+    if tool == nil {
+        return
+    }
+    var req struct {
+        AllowedScopes []string `json:"allowed_scopes"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        logger.Debug("updateToolScopes: invalid JSON: %v", err)
+        http.Error(w, "invalid JSON body", http.StatusBadRequest)
+        return
+    }
+    if req.AllowedScopes == nil {
+        http.Error(w, "allowed_scopes is required", http.StatusBadRequest)
+        return
+    }
+    if s := unsupportedScope(req.AllowedScopes); s != "" {
+        logger.Debug("updateToolScopes: unsupported scope=%s", s)
+        http.Error(w, "unsupported scope in allowed_scopes: "+s, http.StatusBadRequest)
+        return
+    }
+    ok, err := h.repo.UpdateToolAllowedScopes(r.Context(), tool.ID, req.AllowedScopes)
+    if err != nil {
+        logger.Error("update allowed scopes of tool %d: %v", tool.ID, err)
+        http.Error(w, "failed to update tool", http.StatusInternalServerError)
+        return
+    }
+    if !ok {
+        http.NotFound(w, r)
+        return
+    }
+    tool.AllowedScopes = req.AllowedScopes
+    logger.Debug("updateToolScopes: id=%d allowed_scopes=%v", tool.ID, tool.AllowedScopes)
+    w.Header().Set("Content-Type", "application/json")
+    _ = json.NewEncoder(w).Encode(tool)
+}
+
+// unsupportedScope returns the first scope the platform cannot grant, or "" when all are supported.
+func unsupportedScope(scopes []string) string {
+    for _, s := range scopes {
+        if !containsString(repoIface.ServiceScopes, s) {
+            return s
+        }
+    }
+    return ""
+}
@@ -0,0 +1,178 @@
+package lti
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/quipper/poc/lti/be/pkg/common/logger"
+	rosterRepo "github.com/quipper/poc/lti/be/pkg/repositories/roster"
+	usersRepo "github.com/quipper/poc/lti/be/pkg/repositories/users"
+)
+
+// launchUser resolves the platform user a launch is started for.
+// When PLATFORM_USER_HEADER is configured, that header identifies the authenticated user and a
+// login_hint, if sent, must refer to the same user. Otherwise login_hint (user ID or email) is used,
+// which suits the sandbox FE. Returns nil when no known user can be resolved.
+func (h *Handler) launchUser(r *http.Request, loginHint string) (*usersRepo.User, error) {
+	ident := loginHint
+	if h.userHeader != "" {
+		ident = strings.TrimSpace(r.Header.Get(h.userHeader))
+		if ident == "" {
+			logger.Debug("launchUser: missing %s header", h.userHeader)
+			return nil, nil
+		}
+	}
+	u, err := h.lookupUser(r.Context(), ident)
+	if err != nil || u == nil {
+		return nil, err
+	}
+	if h.userHeader != "" && loginHint != "" && loginHint != u.ID && !strings.EqualFold(loginHint, u.Email) {
+		logger.Debug("launchUser: login_hint=%s does not match authenticated user id=%s", loginHint, u.ID)
+		return nil, nil
+	}
+	return u, nil
+}
+
+// lookupUser finds a user by ID, falling back to email for identifiers that look like one.
+func (h *Handler) lookupUser(ctx context.Context, ident string) (*usersRepo.User, error) {
+	if ident == "" || h.users == nil {
+		return nil, nil
+	}
+	u, err := h.users.GetUserByID(ctx, ident)
+	if err != nil || u != nil {
+		return u, err
+	}
+	if strings.Contains(ident, "@") {
+		return h.users.GetUserByEmail(ctx, ident)
+	}
+	return nil, nil
+}
+
+// membershipStatusCustomKey is the custom parameter that marks launches by Inactive roster
+// members (LTI_INACTIVE_MEMBERS=mark) in the standard custom claim.
+const membershipStatusCustomKey = "membership_status"
+
+// launchMember returns the user's roster membership in the launch context, matching the
+// member user_id against the user's ID (the login_hint) and then their email.
+func (h *Handler) launchMember(ctx context.Context, contextID string, u *usersRepo.User) (*rosterRepo.Member, error) {
+	if contextID == "" || h.roster == nil {
+		return nil, nil
+	}
+	m, err := h.roster.GetMember(ctx, contextID, u.ID)
+	if err != nil || m != nil || u.Email == "" {
+		return m, err
+	}
+	return h.roster.GetMember(ctx, contextID, u.Email)
+}
+
+// This is NOT LTI Spec. Admin endpoints to manage platform users.
+// listUsers GET /api/users
+func (h *Handler) listUsers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	items, err := h.users.ListUsers(r.Context())
+	if err != nil {
+		logger.Error("list users: %v", err)
+		http.Error(w, "failed to list users", http.StatusInternalServerError)
+		return
+	}
+	if items == nil {
+		items = []*usersRepo.User{}
+	}
+	logger.Debug("listUsers: returned %d items", len(items))
+	_ = json.NewEncoder(w).Encode(items)
+}
+
+// createUser POST /api/users  body: {"email", "name", "given_name", "family_name", "roles", "id" (optional)}
+func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
+	var req usersRepo.User
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Email) == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+	if existing, err := h.users.GetUserByEmail(r.Context(), req.Email); err != nil {
+		logger.Error("get user by email: %v", err)
+		http.Error(w, "failed to create user", http.StatusInternalServerError)
+		return
+	} else if existing != nil {
+		http.Error(w, "email already in use", http.StatusConflict)
+		return
+	}
+	if err := h.users.CreateUser(r.Context(), &req); err != nil {
+		logger.Error("create user: %v", err)
+		http.Error(w, "failed to create user", http.StatusInternalServerError)
+		return
+	}
+	logger.Debug("createUser: created id=%s email=%s", req.ID, req.Email)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(req)
+}
+
+// getUser GET /api/users/{id}
+func (h *Handler) getUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	u, err := h.users.GetUserByID(r.Context(), id)
+	if err != nil {
+		logger.Error("get user %s: %v", id, err)
+		http.Error(w, "failed to get user", http.StatusInternalServerError)
+		return
+	}
+	if u == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(u)
+}
+
+// updateUser PUT /api/users/{id}  replaces profile and institution roles.
+func (h *Handler) updateUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req usersRepo.User
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Email) == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+	if existing, err := h.users.GetUserByEmail(r.Context(), req.Email); err != nil {
+		logger.Error("get user by email: %v", err)
+		http.Error(w, "failed to update user", http.StatusInternalServerError)
+		return
+	} else if existing != nil && existing.ID != id {
+		http.Error(w, "email already in use", http.StatusConflict)
+		return
+	}
+	req.ID = id
+	ok, err := h.users.UpdateUser(r.Context(), &req)
+	if err != nil {
+		logger.Error("update user %s: %v", id, err)
+		http.Error(w, "failed to update user", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	h.getUser(w, r)
+}
+
+// deleteUser DELETE /api/users/{id}
+func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := h.users.DeleteUser(r.Context(), id); err != nil {
+		logger.Error("delete user %s: %v", id, err)
+		http.Error(w, "failed to delete user", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
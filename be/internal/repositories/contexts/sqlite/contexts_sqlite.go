@@ -0,0 +1,138 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	cr "github.com/quipper/poc/lti/be/pkg/repositories/contexts"
+)
+
+type SQLiteRepo struct{ db *sql.DB }
+
+// Ensure interface compliance
+var _ cr.Repository = (*SQLiteRepo)(nil)
+
+func NewSQLiteRepo(path string) (*SQLiteRepo, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := initSchema(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &SQLiteRepo{db: db}, nil
+}
+
+func (s *SQLiteRepo) Disconnect() { _ = s.db.Close() }
+
+func initSchema(db *sql.DB) error {
+	var existed int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'contexts'`).Scan(&existed)
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS contexts (
+	  id TEXT PRIMARY KEY,
+	  label TEXT,
+	  title TEXT,
+	  types_json TEXT,
+	  created_at TIMESTAMP NOT NULL,
+	  updated_at TIMESTAMP NOT NULL
+	);
+	`)
+	if err != nil || existed > 0 {
+		return err
+	}
+	// Seed the sandbox FE's default context so launches keep working on a fresh database.
+	now := time.Now().UTC()
+	_, err = db.Exec(`INSERT INTO contexts (id, label, title, types_json, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		"dev-context", "DEV", "Development Course", `["http://purl.imsglobal.org/vocab/lis/v2/course#CourseOffering"]`, now, now)
+	return err
+}
+
+const contextColumns = `id, COALESCE(label, ''), COALESCE(title, ''), COALESCE(types_json, ''), created_at, updated_at`
+
+func scanContext(row interface{ Scan(...any) error }) (*cr.Context, error) {
+	var c cr.Context
+	var typesJSON string
+	if err := row.Scan(&c.ID, &c.Label, &c.Title, &typesJSON, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if typesJSON != "" {
+		_ = json.Unmarshal([]byte(typesJSON), &c.Types)
+	}
+	return &c, nil
+}
+
+func (s *SQLiteRepo) ListContexts(ctx context.Context) ([]*cr.Context, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+contextColumns+` FROM contexts ORDER BY id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*cr.Context
+	for rows.Next() {
+		c, err := scanContext(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLiteRepo) GetContext(ctx context.Context, id string) (*cr.Context, error) {
+	c, err := scanContext(s.db.QueryRowContext(ctx, `SELECT `+contextColumns+` FROM contexts WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return c, err
+}
+
+func (s *SQLiteRepo) CreateContext(ctx context.Context, c *cr.Context) error {
+	now := time.Now().UTC()
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO contexts (id, label, title, types_json, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`, c.ID, c.Label, c.Title, typesJSON(c.Types), now, now)
+	if err != nil {
+		return err
+	}
+	c.CreatedAt, c.UpdatedAt = now, now
+	return nil
+}
+
+func (s *SQLiteRepo) UpdateContext(ctx context.Context, c *cr.Context) (bool, error) {
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, `UPDATE contexts SET label = ?, title = ?, types_json = ?, updated_at = ? WHERE id = ?`,
+		c.Label, c.Title, typesJSON(c.Types), now, c.ID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	c.UpdatedAt = now
+	return true, nil
+}
+
+func (s *SQLiteRepo) DeleteContext(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM contexts WHERE id = ?`, id)
+	return err
+}
+
+func typesJSON(types []string) string {
+	if len(types) == 0 {
+		return "[]"
+	}
+	b, err := json.Marshal(types)
+	if err != nil {
+		return "[]"
+	}
+	return string(b)
+}
@@ -0,0 +1,223 @@
+package file
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+
+	kr "github.com/quipper/poc/lti/be/pkg/repositories/keys"
+)
+
+// EncryptedFileRepo stores platform keys in a single file encrypted with
+// AES-256-GCM. The encryption key is derived from a passphrase with scrypt.
+type EncryptedFileRepo struct {
+	path       string
+	passphrase []byte
+	mu         sync.Mutex
+}
+
+// Ensure interface compliance
+var _ kr.KeyStore = (*EncryptedFileRepo)(nil)
+
+// envelope is the on-disk format. Only ciphertext carries key material.
+type envelope struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// record mirrors kr.Key including the private key, which kr.Key hides from JSON.
+type record struct {
+	Kid           string     `json:"kid"`
+	Alg           string     `json:"alg"`
+	PrivateKeyPEM string     `json:"private_key_pem"`
+	CreatedAt     time.Time  `json:"created_at"`
+	RetiredAt     *time.Time `json:"retired_at,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+// NewEncryptedFileRepo opens (or lazily creates) the key file at path.
+// It fails fast when an existing file cannot be decrypted with passphrase.
+func NewEncryptedFileRepo(path, passphrase string) (*EncryptedFileRepo, error) {
+	if passphrase == "" {
+		return nil, errors.New("keystore passphrase is required")
+	}
+	r := &EncryptedFileRepo{path: path, passphrase: []byte(passphrase)}
+	if _, err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *EncryptedFileRepo) Disconnect() {}
+
+func (r *EncryptedFileRepo) ListKeys(ctx context.Context) ([]*kr.Key, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	recs, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].CreatedAt.After(recs[j].CreatedAt) })
+	out := make([]*kr.Key, 0, len(recs))
+	for _, rec := range recs {
+		out = append(out, &kr.Key{
+			Kid:           rec.Kid,
+			Alg:           rec.Alg,
+			PrivateKeyPEM: rec.PrivateKeyPEM,
+			CreatedAt:     rec.CreatedAt,
+			RetiredAt:     rec.RetiredAt,
+			ExpiresAt:     rec.ExpiresAt,
+		})
+	}
+	return out, nil
+}
+
+func (r *EncryptedFileRepo) CreateKey(ctx context.Context, k *kr.Key) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	recs, err := r.load()
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if rec.Kid == k.Kid {
+			return fmt.Errorf("kid %q already exists", k.Kid)
+		}
+	}
+	if k.CreatedAt.IsZero() {
+		k.CreatedAt = time.Now().UTC()
+	}
+	recs = append(recs, record{
+		Kid:           k.Kid,
+		Alg:           k.Alg,
+		PrivateKeyPEM: k.PrivateKeyPEM,
+		CreatedAt:     k.CreatedAt.UTC(),
+		RetiredAt:     k.RetiredAt,
+		ExpiresAt:     k.ExpiresAt,
+	})
+	return r.save(recs)
+}
+
+func (r *EncryptedFileRepo) RetireKey(ctx context.Context, kid string, retiredAt, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	recs, err := r.load()
+	if err != nil {
+		return err
+	}
+	for i := range recs {
+		if recs[i].Kid != kid {
+			continue
+		}
+		if recs[i].RetiredAt == nil {
+			t := retiredAt.UTC()
+			recs[i].RetiredAt = &t
+		}
+		e := expiresAt.UTC()
+		recs[i].ExpiresAt = &e
+		return r.save(recs)
+	}
+	return kr.ErrNotFound
+}
+
+// load reads and decrypts the key file. A missing file is an empty store.
+func (r *EncryptedFileRepo) load() ([]record, error) {
+	raw, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return nil, fmt.Errorf("keystore file is not a valid envelope: %w", err)
+	}
+	if env.Version != 1 {
+		return nil, fmt.Errorf("unsupported keystore file version %d", env.Version)
+	}
+	gcm, err := r.cipher(env.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, env.Nonce, env.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("keystore decryption failed (wrong passphrase or corrupted file)")
+	}
+	var recs []record
+	if err := json.Unmarshal(plain, &recs); err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// save encrypts recs with a fresh salt and nonce and atomically replaces the file.
+func (r *EncryptedFileRepo) save(recs []record) error {
+	plain, err := json.Marshal(recs)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	gcm, err := r.cipher(salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	out, err := json.Marshal(envelope{
+		Version:    1,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plain, nil),
+	})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), ".keystore-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if err := tmp.Chmod(0o600); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(out); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
+
+func (r *EncryptedFileRepo) cipher(salt []byte) (cipher.AEAD, error) {
+	dk, err := scrypt.Key(r.passphrase, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(dk)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
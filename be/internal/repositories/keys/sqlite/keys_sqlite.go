@@ -0,0 +1,113 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	kr "github.com/quipper/poc/lti/be/pkg/repositories/keys"
+)
+
+// SQLiteRepo is a SQLite-backed platform key store.
+type SQLiteRepo struct {
+	db *sql.DB
+}
+
+// Ensure interface compliance
+var _ kr.KeyStore = (*SQLiteRepo)(nil)
+
+func NewSQLiteRepo(path string) (*SQLiteRepo, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := initSchema(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &SQLiteRepo{db: db}, nil
+}
+
+func (r *SQLiteRepo) Disconnect() {
+	_ = r.db.Close()
+}
+
+func initSchema(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS platform_keys (
+			kid TEXT PRIMARY KEY,
+			alg TEXT NOT NULL,
+			private_key_pem TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			retired_at TIMESTAMP,
+			expires_at TIMESTAMP
+		);
+	`)
+	return err
+}
+
+func (r *SQLiteRepo) ListKeys(ctx context.Context) ([]*kr.Key, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT kid, alg, private_key_pem, created_at, retired_at, expires_at
+		FROM platform_keys ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*kr.Key
+	for rows.Next() {
+		var k kr.Key
+		var retired, expires sql.NullTime
+		if err := rows.Scan(&k.Kid, &k.Alg, &k.PrivateKeyPEM, &k.CreatedAt, &retired, &expires); err != nil {
+			return nil, err
+		}
+		if retired.Valid {
+			t := retired.Time
+			k.RetiredAt = &t
+		}
+		if expires.Valid {
+			t := expires.Time
+			k.ExpiresAt = &t
+		}
+		out = append(out, &k)
+	}
+	return out, rows.Err()
+}
+
+func (r *SQLiteRepo) CreateKey(ctx context.Context, k *kr.Key) error {
+	if k.CreatedAt.IsZero() {
+		k.CreatedAt = time.Now().UTC()
+	}
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO platform_keys (kid, alg, private_key_pem, created_at, retired_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, k.Kid, k.Alg, k.PrivateKeyPEM, k.CreatedAt.UTC(), nullableTime(k.RetiredAt), nullableTime(k.ExpiresAt))
+	return err
+}
+
+func (r *SQLiteRepo) RetireKey(ctx context.Context, kid string, retiredAt, expiresAt time.Time) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE platform_keys SET retired_at = COALESCE(retired_at, ?), expires_at = ?
+		WHERE kid = ?
+	`, retiredAt.UTC(), expiresAt.UTC(), kid)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return kr.ErrNotFound
+	}
+	return nil
+}
+
+func nullableTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
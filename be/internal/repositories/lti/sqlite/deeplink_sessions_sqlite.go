@@ -0,0 +1,124 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	repoIface "github.com/quipper/poc/lti/be/pkg/repositories/lti"
+)
+
+const deepLinkSessionColumns = `id, client_id, COALESCE(deployment_id, ''), COALESCE(context_id, ''), COALESCE(user_id, ''),
+	COALESCE(accept_types_json, ''), accept_multiple, COALESCE(accept_media_types, ''), accept_lineitem,
+	status, item_count, COALESCE(msg, ''), COALESCE(log, ''), COALESCE(errormsg, ''), COALESCE(errorlog, ''), created_at, expires_at, closed_at`
+
+func scanDeepLinkSession(row interface{ Scan(...any) error }) (*repoIface.DeepLinkSession, error) {
+	var s repoIface.DeepLinkSession
+	var acceptTypes string
+	var closed sql.NullTime
+	if err := row.Scan(&s.ID, &s.ClientID, &s.DeploymentID, &s.ContextID, &s.UserID,
+		&acceptTypes, &s.AcceptMultiple, &s.AcceptMediaTypes, &s.AcceptLineItem,
+		&s.Status, &s.ItemCount, &s.Msg, &s.Log, &s.ErrorMsg, &s.ErrorLog, &s.CreatedAt, &s.ExpiresAt, &closed); err != nil {
+		return nil, err
+	}
+	if acceptTypes != "" {
+		_ = json.Unmarshal([]byte(acceptTypes), &s.AcceptTypes)
+	}
+	s.ClosedAt = timePtr(closed)
+	return &s, nil
+}
+
+// CreateDeepLinkSession inserts a deep linking session, assigning a random ID when none is set.
+func (r *SQLiteRepo) CreateDeepLinkSession(ctx context.Context, s *repoIface.DeepLinkSession) error {
+	if s.ID == "" {
+		s.ID = uuid.NewString()
+	}
+	acceptTypes, err := json.Marshal(s.AcceptTypes)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	_, err = r.db.ExecContext(ctx, `
+		INSERT INTO deeplink_sessions (id, client_id, deployment_id, context_id, user_id,
+			accept_types_json, accept_multiple, accept_media_types, accept_lineitem,
+			status, item_count, msg, log, errormsg, errorlog, created_at, expires_at, closed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, s.ID, s.ClientID, s.DeploymentID, s.ContextID, s.UserID,
+		string(acceptTypes), s.AcceptMultiple, s.AcceptMediaTypes, s.AcceptLineItem,
+		s.Status, s.ItemCount, s.Msg, s.Log, s.ErrorMsg, s.ErrorLog, now, s.ExpiresAt.UTC(), nullableTime(s.ClosedAt))
+	if err != nil {
+		return err
+	}
+	s.CreatedAt = now
+	return nil
+}
+
+// CloseDeepLinkSession moves an open, unexpired session to its final status in one statement,
+// so concurrent responses for the same session cannot both close it.
+func (r *SQLiteRepo) CloseDeepLinkSession(ctx context.Context, s *repoIface.DeepLinkSession) (bool, error) {
+	now := time.Now().UTC()
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE deeplink_sessions SET status = ?, msg = ?, log = ?, errormsg = ?, errorlog = ?, closed_at = ?
+		WHERE id = ? AND status = ? AND expires_at > ?
+	`, s.Status, s.Msg, s.Log, s.ErrorMsg, s.ErrorLog, now, s.ID, repoIface.DeepLinkSessionOpen, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+	s.ClosedAt = &now
+	return true, nil
+}
+
+// SetDeepLinkSessionItemCount records how many content items were kept from a session's response.
+func (r *SQLiteRepo) SetDeepLinkSessionItemCount(ctx context.Context, id string, n int) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE deeplink_sessions SET item_count = ? WHERE id = ?`, n, id)
+	return err
+}
+
+// ListDeepLinkSessions returns sessions newest first, optionally limited to one context.
+func (r *SQLiteRepo) ListDeepLinkSessions(ctx context.Context, contextID string) ([]*repoIface.DeepLinkSession, error) {
+	q := `SELECT ` + deepLinkSessionColumns + ` FROM deeplink_sessions`
+	var args []any
+	if contextID != "" {
+		q += ` WHERE context_id = ?`
+		args = append(args, contextID)
+	}
+	rows, err := r.db.QueryContext(ctx, q+` ORDER BY created_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*repoIface.DeepLinkSession
+	for rows.Next() {
+		s, err := scanDeepLinkSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetDeepLinkSession returns a session by ID.
+func (r *SQLiteRepo) GetDeepLinkSession(ctx context.Context, id string) (*repoIface.DeepLinkSession, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+deepLinkSessionColumns+` FROM deeplink_sessions WHERE id = ?`, id)
+	s, err := scanDeepLinkSession(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,84 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	repoIface "github.com/quipper/poc/lti/be/pkg/repositories/lti"
+)
+
+const deploymentColumns = `id, tool_id, deployment_id, COALESCE(label, ''), scope, COALESCE(context_id, ''), COALESCE(custom_json, ''), created_at`
+
+func scanDeployment(row interface{ Scan(...any) error }) (*repoIface.Deployment, error) {
+	var d repoIface.Deployment
+	var custom string
+	if err := row.Scan(&d.ID, &d.ToolID, &d.DeploymentID, &d.Label, &d.Scope, &d.ContextID, &custom, &d.CreatedAt); err != nil {
+		return nil, err
+	}
+	d.Custom = parseCustom(custom)
+	return &d, nil
+}
+
+// CreateDeployment inserts a deployment for a tool and returns its ID.
+func (r *SQLiteRepo) CreateDeployment(ctx context.Context, d *repoIface.Deployment) (int64, error) {
+	return insertDeployment(ctx, r.db, d)
+}
+
+func insertDeployment(ctx context.Context, ex execer, d *repoIface.Deployment) (int64, error) {
+	now := time.Now().UTC()
+	res, err := ex.ExecContext(ctx, `
+		INSERT INTO deployments (tool_id, deployment_id, label, scope, context_id, custom_json, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, d.ToolID, d.DeploymentID, d.Label, d.Scope, d.ContextID, jsonCustom(d.Custom), now)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	d.ID = id
+	d.CreatedAt = now
+	return id, nil
+}
+
+// ListDeployments returns a tool's deployments, oldest first.
+func (r *SQLiteRepo) ListDeployments(ctx context.Context, toolID int64) ([]*repoIface.Deployment, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+deploymentColumns+` FROM deployments WHERE tool_id = ? ORDER BY id ASC`, toolID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*repoIface.Deployment
+	for rows.Next() {
+		d, err := scanDeployment(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetDeployment returns a tool's deployment by deployment_id.
+func (r *SQLiteRepo) GetDeployment(ctx context.Context, toolID int64, deploymentID string) (*repoIface.Deployment, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+deploymentColumns+` FROM deployments WHERE tool_id = ? AND deployment_id = ?`, toolID, deploymentID)
+	d, err := scanDeployment(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return d, nil
+}
+
+// DeleteDeployment deletes a tool's deployment by deployment_id.
+func (r *SQLiteRepo) DeleteDeployment(ctx context.Context, toolID int64, deploymentID string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM deployments WHERE tool_id = ? AND deployment_id = ?`, toolID, deploymentID)
+	return err
+}
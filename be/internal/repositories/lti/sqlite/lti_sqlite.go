@@ -3,6 +3,7 @@ package sqlite
 import (
 	"database/sql"
 	"context"
+	"encoding/json"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -63,13 +64,70 @@ func (r *SQLiteRepo) ConsumeOIDCState(ctx context.Context, state string) (nonce
     return
 }
 
+const selectionColumns = `id, client_id, tool_name, COALESCE(type, ''), url, COALESCE(item_json, ''), content_item_json, COALESCE(resource_link_id, ''),
+	COALESCE(session_id, ''), COALESCE(context_id, ''), COALESCE(deployment_id, ''), COALESCE(user_id, ''), created_at`
+
+func scanSelection(row interface{ Scan(...any) error }) (*repoIface.DeepLinkSelection, error) {
+	var s repoIface.DeepLinkSelection
+	var item string
+	if err := row.Scan(&s.ID, &s.ClientID, &s.ToolName, &s.Type, &s.URL, &item, &s.ContentItemJSON, &s.ResourceLinkID,
+		&s.SessionID, &s.ContextID, &s.DeploymentID, &s.UserID, &s.CreatedAt); err != nil {
+		return nil, err
+	}
+	if item != "" {
+		var ci repoIface.ContentItem
+		if err := json.Unmarshal([]byte(item), &ci); err == nil {
+			s.Item = &ci
+		}
+	}
+	return &s, nil
+}
+
+// jsonItem encodes a typed content item for the item_json column; nil stays NULL.
+func jsonItem(ci *repoIface.ContentItem) any {
+	if ci == nil {
+		return nil
+	}
+	b, err := json.Marshal(ci)
+	if err != nil {
+		return nil
+	}
+	return string(b)
+}
+
 // CreateDeepLinkSelection inserts a new selection row and returns its ID.
 func (r *SQLiteRepo) CreateDeepLinkSelection(ctx context.Context, sel *repoIface.DeepLinkSelection) (int64, error) {
+	return insertDeepLinkSelection(ctx, r.db, sel)
+}
+
+// CreateDeepLinkSelectionWithLink stores the resource link created for a selection (when link is
+// non-nil) and the selection in a single transaction, so neither is left without the other.
+func (r *SQLiteRepo) CreateDeepLinkSelectionWithLink(ctx context.Context, sel *repoIface.DeepLinkSelection, link *repoIface.ResourceLink) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if link != nil {
+		if err := insertResourceLink(ctx, tx, link); err != nil {
+			return err
+		}
+		sel.ResourceLinkID = link.ID
+	}
+	if _, err := insertDeepLinkSelection(ctx, tx, sel); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertDeepLinkSelection(ctx context.Context, ex execer, sel *repoIface.DeepLinkSelection) (int64, error) {
     now := time.Now().UTC()
-    res, err := r.db.ExecContext(ctx, `
-        INSERT INTO deeplink_selections (client_id, tool_name, url, content_item_json, created_at)
-        VALUES (?, ?, ?, ?, ?)
-    `, sel.ClientID, sel.ToolName, sel.URL, sel.ContentItemJSON, now)
+    res, err := ex.ExecContext(ctx, `
+        INSERT INTO deeplink_selections (client_id, tool_name, type, url, item_json, content_item_json, resource_link_id,
+            session_id, context_id, deployment_id, user_id, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `, sel.ClientID, sel.ToolName, sel.Type, sel.URL, jsonItem(sel.Item), sel.ContentItemJSON, sel.ResourceLinkID,
+        sel.SessionID, sel.ContextID, sel.DeploymentID, sel.UserID, now)
 	if err != nil {
 		return 0, err
 	}
@@ -85,7 +143,7 @@ func (r *SQLiteRepo) CreateDeepLinkSelection(ctx context.Context, sel *repoIface
 // ListDeepLinkSelections returns all selections ordered by newest first.
 func (r *SQLiteRepo) ListDeepLinkSelections(ctx context.Context) ([]*repoIface.DeepLinkSelection, error) {
     rows, err := r.db.QueryContext(ctx, `
-        SELECT id, client_id, tool_name, url, content_item_json, created_at
+        SELECT `+selectionColumns+`
         FROM deeplink_selections ORDER BY id DESC`)
 	if err != nil {
 		return nil, err
@@ -93,13 +151,11 @@ func (r *SQLiteRepo) ListDeepLinkSelections(ctx context.Context) ([]*repoIface.D
 	defer rows.Close()
 	var out []*repoIface.DeepLinkSelection
 	for rows.Next() {
-		var s repoIface.DeepLinkSelection
-		var created time.Time
-		        if err := rows.Scan(&s.ID, &s.ClientID, &s.ToolName, &s.URL, &s.ContentItemJSON, &created); err != nil {
-            return nil, err
-        }
-		s.CreatedAt = created
-		out = append(out, &s)
+		s, err := scanSelection(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -110,18 +166,16 @@ func (r *SQLiteRepo) ListDeepLinkSelections(ctx context.Context) ([]*repoIface.D
 // GetDeepLinkSelection returns a selection by ID.
 func (r *SQLiteRepo) GetDeepLinkSelection(ctx context.Context, id int64) (*repoIface.DeepLinkSelection, error) {
     row := r.db.QueryRowContext(ctx, `
-        SELECT id, client_id, tool_name, url, content_item_json, created_at
+        SELECT `+selectionColumns+`
         FROM deeplink_selections WHERE id = ?`, id)
-    var s repoIface.DeepLinkSelection
-    var created time.Time
-    if err := row.Scan(&s.ID, &s.ClientID, &s.ToolName, &s.URL, &s.ContentItemJSON, &created); err != nil {
+    s, err := scanSelection(row)
+    if err != nil {
         if err == sql.ErrNoRows {
             return nil, nil
         }
         return nil, err
     }
-    s.CreatedAt = created
-    return &s, nil
+    return s, nil
 }
 
 // DeleteDeepLinkSelection deletes a selection by ID.
@@ -138,12 +192,48 @@ func NewSQLiteRepo(path string) (*SQLiteRepo, error) {
 	if err := db.Ping(); err != nil {
 		return nil, err
 	}
+	var hadDeployments int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'deployments'`).Scan(&hadDeployments)
+	var hadResourceLinks int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'resource_links'`).Scan(&hadResourceLinks)
 	if err := initSchema(db); err != nil {
 		return nil, err
 	}
     // Best-effort migration: add target_launch_url if column not yet present and remove token_url if present
     _, _ = db.Exec(`ALTER TABLE tools ADD COLUMN target_launch_url TEXT`)
     _, _ = db.Exec(`ALTER TABLE tools DROP COLUMN token_url`)
+    _, _ = db.Exec(`ALTER TABLE tools ADD COLUMN id_token_alg TEXT`)
+    _, _ = db.Exec(`ALTER TABLE tools ADD COLUMN redirect_uris_json TEXT`)
+    _, _ = db.Exec(`ALTER TABLE tools ADD COLUMN custom_json TEXT`)
+    // Tools registered before scopes were restricted keep NULL here until the server sets the
+    // scopes they used (see ListToolsWithoutAllowedScopes).
+    _, _ = db.Exec(`ALTER TABLE tools ADD COLUMN allowed_scopes_json TEXT`)
+    _, _ = db.Exec(`ALTER TABLE deployments ADD COLUMN custom_json TEXT`)
+    _, _ = db.Exec(`ALTER TABLE resource_links ADD COLUMN available_start TIMESTAMP`)
+    _, _ = db.Exec(`ALTER TABLE resource_links ADD COLUMN available_end TIMESTAMP`)
+    _, _ = db.Exec(`ALTER TABLE resource_links ADD COLUMN submission_start TIMESTAMP`)
+    _, _ = db.Exec(`ALTER TABLE resource_links ADD COLUMN submission_end TIMESTAMP`)
+    // Tools registered before deployments existed keep launching with the former fixed deployment_id.
+    if hadDeployments == 0 {
+        _, _ = db.Exec(`INSERT INTO deployments (tool_id, deployment_id, scope, created_at) SELECT id, 'dev-deployment', 'institution', CURRENT_TIMESTAMP FROM tools`)
+    }
+    _, _ = db.Exec(`ALTER TABLE deeplink_selections ADD COLUMN resource_link_id TEXT`)
+    _, _ = db.Exec(`ALTER TABLE deeplink_selections ADD COLUMN type TEXT`)
+    _, _ = db.Exec(`ALTER TABLE deeplink_selections ADD COLUMN item_json TEXT`)
+    _, _ = db.Exec(`ALTER TABLE deeplink_selections ADD COLUMN session_id TEXT`)
+    _, _ = db.Exec(`ALTER TABLE deeplink_selections ADD COLUMN context_id TEXT`)
+    _, _ = db.Exec(`ALTER TABLE deeplink_selections ADD COLUMN deployment_id TEXT`)
+    _, _ = db.Exec(`ALTER TABLE deeplink_selections ADD COLUMN user_id TEXT`)
+    // Selections used to double as resource links (their row ID was the resource_link_id);
+    // give each one a resource link with that same ID so existing launches and line items keep working.
+    if hadResourceLinks == 0 {
+        _, _ = db.Exec(`
+            INSERT INTO resource_links (id, tool_id, title, url, custom_json, created_at, updated_at)
+            SELECT CAST(s.id AS TEXT), COALESCE((SELECT t.id FROM tools t WHERE t.client_id = s.client_id OR t.name = s.tool_name LIMIT 1), 0),
+                json_extract(s.content_item_json, '$.title'), s.url, json_extract(s.content_item_json, '$.custom'), s.created_at, s.created_at
+            FROM deeplink_selections s`)
+        _, _ = db.Exec(`UPDATE deeplink_selections SET resource_link_id = CAST(id AS TEXT) WHERE resource_link_id IS NULL`)
+    }
 	return &SQLiteRepo{db: db, wg: &sync.WaitGroup{}}, nil
 }
 
@@ -157,6 +247,10 @@ func initSchema(db *sql.DB) error {
             target_link_url TEXT,
             target_launch_url TEXT,
             key_set_url TEXT,
+            id_token_alg TEXT,
+            redirect_uris_json TEXT,
+            custom_json TEXT,
+            allowed_scopes_json TEXT,
             created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
         );
         CREATE TABLE IF NOT EXISTS oidc_states (
@@ -178,10 +272,64 @@ func initSchema(db *sql.DB) error {
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			client_id TEXT,
 			tool_name TEXT,
+			type TEXT,
 			url TEXT,
+			item_json TEXT,
 			content_item_json TEXT NOT NULL,
+			resource_link_id TEXT,
+			session_id TEXT,
+			context_id TEXT,
+			deployment_id TEXT,
+			user_id TEXT,
 			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 		);
+		CREATE TABLE IF NOT EXISTS deployments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			tool_id INTEGER NOT NULL,
+			deployment_id TEXT NOT NULL,
+			label TEXT,
+			scope TEXT NOT NULL,
+			context_id TEXT,
+			custom_json TEXT,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(tool_id, deployment_id)
+		);
+		CREATE TABLE IF NOT EXISTS resource_links (
+			id TEXT PRIMARY KEY,
+			context_id TEXT,
+			tool_id INTEGER NOT NULL,
+			deployment_id TEXT,
+			title TEXT,
+			description TEXT,
+			url TEXT,
+			custom_json TEXT,
+			available_start TIMESTAMP,
+			available_end TIMESTAMP,
+			submission_start TIMESTAMP,
+			submission_end TIMESTAMP,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS deeplink_sessions (
+			id TEXT PRIMARY KEY,
+			client_id TEXT NOT NULL,
+			deployment_id TEXT,
+			context_id TEXT,
+			user_id TEXT,
+			accept_types_json TEXT,
+			accept_multiple INTEGER NOT NULL DEFAULT 0,
+			accept_media_types TEXT,
+			accept_lineitem INTEGER NOT NULL DEFAULT 1,
+			status TEXT NOT NULL,
+			item_count INTEGER NOT NULL DEFAULT 0,
+			msg TEXT,
+			log TEXT,
+			errormsg TEXT,
+			errorlog TEXT,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			closed_at TIMESTAMP
+		);
 	`)
 	return err
 }
@@ -199,12 +347,40 @@ func (r *SQLiteRepo) Disconnect() {
 }
 
 // RegisterTool inserts a new tool and returns its ID
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
 func (r *SQLiteRepo) RegisterTool(ctx context.Context, t *repoIface.Tool) (int64, error) {
+	return insertTool(ctx, r.db, t)
+}
+
+// RegisterToolWithDeployment inserts t and its deployment d in a single transaction,
+// so a tool is never left without its deployment.
+func (r *SQLiteRepo) RegisterToolWithDeployment(ctx context.Context, t *repoIface.Tool, d *repoIface.Deployment) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	id, err := insertTool(ctx, tx, t)
+	if err != nil {
+		return err
+	}
+	d.ToolID = id
+	if _, err := insertDeployment(ctx, tx, d); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertTool(ctx context.Context, ex execer, t *repoIface.Tool) (int64, error) {
 	now := time.Now().UTC()
-	res, err := r.db.ExecContext(ctx, `
-        INSERT INTO tools (name, client_id, auth_url, target_link_url, target_launch_url, key_set_url, created_at)
-        VALUES (?, ?, ?, ?, ?, ?, ?)
-    `, t.Name, t.ClientID, t.AuthURL, t.TargetLinkURL, t.TargetLaunchURL, t.KeySetURL, now)
+	res, err := ex.ExecContext(ctx, `
+        INSERT INTO tools (name, client_id, auth_url, target_link_url, target_launch_url, key_set_url, id_token_alg, redirect_uris_json, custom_json, allowed_scopes_json, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `, t.Name, t.ClientID, t.AuthURL, t.TargetLinkURL, t.TargetLaunchURL, t.KeySetURL, t.IDTokenAlg, jsonStrings(t.RedirectURIs), jsonCustom(t.Custom), jsonScopes(t.AllowedScopes), now)
 	if err != nil {
 		return 0, err
 	}
@@ -217,26 +393,102 @@ func (r *SQLiteRepo) RegisterTool(ctx context.Context, t *repoIface.Tool) (int64
 	return id, nil
 }
 
+// UpdateToolAllowedScopes replaces the service scopes a tool may be granted.
+func (r *SQLiteRepo) UpdateToolAllowedScopes(ctx context.Context, id int64, scopes []string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `UPDATE tools SET allowed_scopes_json = ? WHERE id = ?`, jsonScopes(scopes), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// DeleteToolByID deletes the tool with its deployments and resource links in a single transaction.
 func (r *SQLiteRepo) DeleteToolByID(ctx context.Context, id int64) error {
-	_, err := r.db.ExecContext(ctx, `DELETE FROM tools WHERE id = ?`, id)
-	return err
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM deployments WHERE tool_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM resource_links WHERE tool_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tools WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// toolColumns is the column list shared by all tool SELECTs; keep in sync with scanTool.
+// Columns added by migrations are COALESCEd since existing rows hold NULL.
+const toolColumns = `id, name, client_id, auth_url, target_link_url, COALESCE(target_launch_url, ''), key_set_url, COALESCE(id_token_alg, ''), COALESCE(redirect_uris_json, ''), COALESCE(custom_json, ''), COALESCE(allowed_scopes_json, '[]'), created_at`
+
+// scanTool scans a row selected with toolColumns.
+func scanTool(row interface{ Scan(...any) error }) (*repoIface.Tool, error) {
+	var t repoIface.Tool
+	var created time.Time
+	var redirectURIs, custom, allowedScopes string
+	if err := row.Scan(&t.ID, &t.Name, &t.ClientID, &t.AuthURL, &t.TargetLinkURL, &t.TargetLaunchURL, &t.KeySetURL, &t.IDTokenAlg, &redirectURIs, &custom, &allowedScopes, &created); err != nil {
+		return nil, err
+	}
+	t.AllowedScopes = []string{}
+	_ = json.Unmarshal([]byte(allowedScopes), &t.AllowedScopes)
+	t.Custom = parseCustom(custom)
+	if redirectURIs != "" {
+		_ = json.Unmarshal([]byte(redirectURIs), &t.RedirectURIs)
+	}
+	t.CreatedAt = created
+	return &t, nil
+}
+
+// jsonStrings encodes a string slice for a *_json TEXT column; nil stays NULL.
+func jsonStrings(v []string) any {
+	if len(v) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return string(b)
+}
+
+// jsonScopes encodes an allowed scope list; unlike jsonStrings an empty list is stored as [] (nothing allowed).
+func jsonScopes(v []string) string {
+	if v == nil {
+		v = []string{}
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
 }
 
 func (r *SQLiteRepo) ListTools(ctx context.Context) ([]*repoIface.Tool, error) {
-	rows, err := r.db.QueryContext(ctx, `SELECT id, name, client_id, auth_url, target_link_url, target_launch_url, key_set_url, created_at FROM tools ORDER BY id ASC`)
+	return r.listTools(ctx, `SELECT `+toolColumns+` FROM tools ORDER BY id ASC`)
+}
+
+func (r *SQLiteRepo) ListToolsWithoutAllowedScopes(ctx context.Context) ([]*repoIface.Tool, error) {
+	return r.listTools(ctx, `SELECT `+toolColumns+` FROM tools WHERE allowed_scopes_json IS NULL ORDER BY id ASC`)
+}
+
+func (r *SQLiteRepo) listTools(ctx context.Context, query string) ([]*repoIface.Tool, error) {
+	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var out []*repoIface.Tool
 	for rows.Next() {
-		var t repoIface.Tool
-		var created time.Time
-		if err := rows.Scan(&t.ID, &t.Name, &t.ClientID, &t.AuthURL, &t.TargetLinkURL, &t.TargetLaunchURL, &t.KeySetURL, &created); err != nil {
+		t, err := scanTool(rows)
+		if err != nil {
 			return nil, err
 		}
-		t.CreatedAt = created
-		out = append(out, &t)
+		out = append(out, t)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -245,32 +497,28 @@ func (r *SQLiteRepo) ListTools(ctx context.Context) ([]*repoIface.Tool, error) {
 }
 
 func (r *SQLiteRepo) GetToolByID(ctx context.Context, id int64) (*repoIface.Tool, error) {
-	row := r.db.QueryRowContext(ctx, `SELECT id, name, client_id, auth_url, target_link_url, target_launch_url, key_set_url, created_at FROM tools WHERE id = ?`, id)
-	var t repoIface.Tool
-	var created time.Time
-	if err := row.Scan(&t.ID, &t.Name, &t.ClientID, &t.AuthURL, &t.TargetLinkURL, &t.TargetLaunchURL, &t.KeySetURL, &created); err != nil {
+	row := r.db.QueryRowContext(ctx, `SELECT `+toolColumns+` FROM tools WHERE id = ?`, id)
+	t, err := scanTool(row)
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
 	}
-	t.CreatedAt = created
-	return &t, nil
+	return t, nil
 }
 
 // GetToolByClientID returns a tool by client_id.
 func (r *SQLiteRepo) GetToolByClientID(ctx context.Context, clientID string) (*repoIface.Tool, error) {
-	row := r.db.QueryRowContext(ctx, `SELECT id, name, client_id, auth_url, target_link_url, target_launch_url, key_set_url, created_at FROM tools WHERE client_id = ?`, clientID)
-	var t repoIface.Tool
-	var created time.Time
-	if err := row.Scan(&t.ID, &t.Name, &t.ClientID, &t.AuthURL, &t.TargetLinkURL, &t.TargetLaunchURL, &t.KeySetURL, &created); err != nil {
+	row := r.db.QueryRowContext(ctx, `SELECT `+toolColumns+` FROM tools WHERE client_id = ?`, clientID)
+	t, err := scanTool(row)
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
 	}
-	t.CreatedAt = created
-	return &t, nil
+	return t, nil
 }
 
 // TryUseClientAssertionJTI records a client_assertion jti if it does not already exist and is not expired.
@@ -0,0 +1,153 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	repoIface "github.com/quipper/poc/lti/be/pkg/repositories/lti"
+)
+
+const resourceLinkColumns = `id, COALESCE(context_id, ''), tool_id, COALESCE(deployment_id, ''), COALESCE(title, ''), COALESCE(description, ''), COALESCE(url, ''), COALESCE(custom_json, ''), available_start, available_end, submission_start, submission_end, created_at, updated_at`
+
+func scanResourceLink(row interface{ Scan(...any) error }) (*repoIface.ResourceLink, error) {
+	var l repoIface.ResourceLink
+	var custom string
+	var availStart, availEnd, subStart, subEnd sql.NullTime
+	if err := row.Scan(&l.ID, &l.ContextID, &l.ToolID, &l.DeploymentID, &l.Title, &l.Description, &l.URL, &custom, &availStart, &availEnd, &subStart, &subEnd, &l.CreatedAt, &l.UpdatedAt); err != nil {
+		return nil, err
+	}
+	l.Custom = parseCustom(custom)
+	l.AvailableStart = timePtr(availStart)
+	l.AvailableEnd = timePtr(availEnd)
+	l.SubmissionStart = timePtr(subStart)
+	l.SubmissionEnd = timePtr(subEnd)
+	return &l, nil
+}
+
+// jsonCustom encodes custom parameters for a custom_json column; empty stays NULL.
+func jsonCustom(v map[string]string) any {
+	if len(v) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return string(b)
+}
+
+// parseCustom decodes a custom_json column value.
+func parseCustom(s string) map[string]string {
+	if s == "" {
+		return nil
+	}
+	var out map[string]string
+	_ = json.Unmarshal([]byte(s), &out)
+	return out
+}
+
+func nullableTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// CreateResourceLink inserts a resource link, assigning a random ID when none is set.
+func (r *SQLiteRepo) CreateResourceLink(ctx context.Context, l *repoIface.ResourceLink) error {
+	return insertResourceLink(ctx, r.db, l)
+}
+
+func insertResourceLink(ctx context.Context, ex execer, l *repoIface.ResourceLink) error {
+	if l.ID == "" {
+		l.ID = uuid.NewString()
+	}
+	now := time.Now().UTC()
+	_, err := ex.ExecContext(ctx, `
+		INSERT INTO resource_links (id, context_id, tool_id, deployment_id, title, description, url, custom_json,
+			available_start, available_end, submission_start, submission_end, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, l.ID, l.ContextID, l.ToolID, l.DeploymentID, l.Title, l.Description, l.URL, jsonCustom(l.Custom),
+		nullableTime(l.AvailableStart), nullableTime(l.AvailableEnd), nullableTime(l.SubmissionStart), nullableTime(l.SubmissionEnd), now, now)
+	if err != nil {
+		return err
+	}
+	l.CreatedAt = now
+	l.UpdatedAt = now
+	return nil
+}
+
+// ListResourceLinks returns resource links newest first, optionally limited to one context.
+func (r *SQLiteRepo) ListResourceLinks(ctx context.Context, contextID string) ([]*repoIface.ResourceLink, error) {
+	q := `SELECT ` + resourceLinkColumns + ` FROM resource_links`
+	var args []any
+	if contextID != "" {
+		q += ` WHERE context_id = ?`
+		args = append(args, contextID)
+	}
+	rows, err := r.db.QueryContext(ctx, q+` ORDER BY created_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*repoIface.ResourceLink
+	for rows.Next() {
+		l, err := scanResourceLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetResourceLink returns a resource link by ID.
+func (r *SQLiteRepo) GetResourceLink(ctx context.Context, id string) (*repoIface.ResourceLink, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+resourceLinkColumns+` FROM resource_links WHERE id = ?`, id)
+	l, err := scanResourceLink(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return l, nil
+}
+
+// UpdateResourceLink replaces the descriptive fields of a resource link.
+func (r *SQLiteRepo) UpdateResourceLink(ctx context.Context, l *repoIface.ResourceLink) (bool, error) {
+	now := time.Now().UTC()
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE resource_links SET title = ?, description = ?, url = ?, custom_json = ?,
+			available_start = ?, available_end = ?, submission_start = ?, submission_end = ?, updated_at = ?
+		WHERE id = ?
+	`, l.Title, l.Description, l.URL, jsonCustom(l.Custom),
+		nullableTime(l.AvailableStart), nullableTime(l.AvailableEnd), nullableTime(l.SubmissionStart), nullableTime(l.SubmissionEnd), now, l.ID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// DeleteResourceLink deletes a resource link by ID.
+func (r *SQLiteRepo) DeleteResourceLink(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM resource_links WHERE id = ?`, id)
+	return err
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -100,6 +101,29 @@ func (s *SQLiteRepo) ListMembersPage(ctx context.Context, contextID string, offs
     return out, total, nil
 }
 
+func (s *SQLiteRepo) GetMember(ctx context.Context, contextID, userID string) (*r.Member, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT user_id, name, given_name, family_name, email, roles_json, status, updated_at FROM members WHERE context_id = ? AND user_id = ?`, contextID, userID)
+	var m r.Member
+	var rolesStr sql.NullString
+	var name, given, family, email sql.NullString
+	var status sql.NullString
+	var ts time.Time
+	if err := row.Scan(&m.UserID, &name, &given, &family, &email, &rolesStr, &status, &ts); err != nil {
+		if errors.Is(err, sql.ErrNoRows) { return nil, nil }
+		return nil, err
+	}
+	if name.Valid { m.Name = name.String }
+	if given.Valid { m.GivenName = given.String }
+	if family.Valid { m.FamilyName = family.String }
+	if email.Valid { m.Email = email.String }
+	if status.Valid { m.Status = status.String }
+	if rolesStr.Valid && rolesStr.String != "" {
+		_ = json.Unmarshal([]byte(rolesStr.String), &m.Roles)
+	}
+	m.UpdatedAt = ts
+	return &m, nil
+}
+
 func (s *SQLiteRepo) UpsertMember(ctx context.Context, contextID string, m *r.Member) error {
 	rolesJSON := "[]"
 	if b, err := json.Marshal(m.Roles); err == nil { rolesJSON = string(b) }
@@ -3,6 +3,7 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -71,6 +72,8 @@ func initSchema(db *sql.DB) error {
 			score_maximum REAL NOT NULL,
 			start_at TIMESTAMP,
 			end_at TIMESTAMP,
+			grades_released INTEGER,
+			client_id TEXT,
 			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 		);
@@ -91,6 +94,21 @@ func initSchema(db *sql.DB) error {
 			grading_progress TEXT,
 			UNIQUE(line_item_id, context_id, user_id)
 		);
+		CREATE TABLE IF NOT EXISTS scores (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			line_item_id INTEGER NOT NULL,
+			context_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			client_id TEXT NOT NULL,
+			score_given REAL,
+			score_maximum REAL,
+			comment TEXT,
+			timestamp TIMESTAMP NOT NULL,
+			activity_progress TEXT,
+			grading_progress TEXT,
+			received_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_scores_line_item_user ON scores(line_item_id, context_id, user_id);
 	`)
 	if err != nil {
 		return err
@@ -99,6 +117,48 @@ func initSchema(db *sql.DB) error {
 	if err := migrateResultsColumns(db); err != nil {
 		return err
 	}
+	if err := migrateLineItemColumns(db); err != nil {
+		return err
+	}
+	return nil
+}
+
+func migrateLineItemColumns(db *sql.DB) error {
+	rows, err := db.Query(`PRAGMA table_info(line_items)`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	needGradesReleased := true
+	needClientID := true
+	for rows.Next() {
+		var cid int
+		var name, ctype string
+		var notnull, pk int
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk); err != nil {
+			return err
+		}
+		switch name {
+		case "grades_released":
+			needGradesReleased = false
+		case "client_id":
+			needClientID = false
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if needGradesReleased {
+		if _, err := db.Exec(`ALTER TABLE line_items ADD COLUMN grades_released INTEGER`); err != nil {
+			return err
+		}
+	}
+	if needClientID {
+		if _, err := db.Exec(`ALTER TABLE line_items ADD COLUMN client_id TEXT`); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -139,12 +199,46 @@ func migrateResultsColumns(db *sql.DB) error {
 	return nil
 }
 
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
 func (r *SQLiteRepo) CreateLineItem(ctx context.Context, li *sc.LineItem) (int64, error) {
+	return insertLineItem(ctx, r.db, li)
+}
+
+// CreateLineItemWithMapping inserts li and its line_item_mappings row to li.ResourceLinkID in one transaction.
+func (r *SQLiteRepo) CreateLineItemWithMapping(ctx context.Context, li *sc.LineItem) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	id, err := insertLineItem(ctx, tx, li)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO line_item_mappings (line_item_id, resource_link_id)
+		VALUES (?, ?)
+	`, id, li.ResourceLinkID); err != nil {
+		li.ID = 0
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		li.ID = 0
+		return 0, err
+	}
+	return id, nil
+}
+
+func insertLineItem(ctx context.Context, ex execer, li *sc.LineItem) (int64, error) {
 	now := time.Now().UTC()
-	res, err := r.db.ExecContext(ctx, `
-		INSERT INTO line_items (context_id, label, resource_id, resource_link_id, tag, score_maximum, start_at, end_at, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, li.ContextID, li.Label, li.ResourceID, li.ResourceLinkID, li.Tag, li.ScoreMaximum, nullableTime(li.StartAt), nullableTime(li.EndAt), now, now)
+	res, err := ex.ExecContext(ctx, `
+		INSERT INTO line_items (context_id, label, resource_id, resource_link_id, tag, score_maximum, start_at, end_at, grades_released, client_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, li.ContextID, li.Label, li.ResourceID, li.ResourceLinkID, li.Tag, li.ScoreMaximum, nullableTime(li.StartAt), nullableTime(li.EndAt), nullableBool(li.GradesReleased), li.ClientID, now, now)
 	if err != nil {
 		return 0, err
 	}
@@ -158,50 +252,20 @@ func (r *SQLiteRepo) CreateLineItem(ctx context.Context, li *sc.LineItem) (int64
 	return id, nil
 }
 
-func (r *SQLiteRepo) ListLineItems(ctx context.Context, contextID string) ([]*sc.LineItem, error) {
-	rows, err := r.db.QueryContext(ctx, `
-		SELECT id, context_id, label, resource_id, resource_link_id, tag, score_maximum, start_at, end_at, created_at, updated_at
-		FROM line_items WHERE context_id = ? ORDER BY id ASC`, contextID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var out []*sc.LineItem
-	for rows.Next() {
-		var li sc.LineItem
-		var start, end, created, updated sql.NullTime
-		if err := rows.Scan(&li.ID, &li.ContextID, &li.Label, &li.ResourceID, &li.ResourceLinkID, &li.Tag, &li.ScoreMaximum, &start, &end, &created, &updated); err != nil {
-			return nil, err
-		}
-		if start.Valid {
-			li.StartAt = &start.Time
-		}
-		if end.Valid {
-			li.EndAt = &end.Time
-		}
-		if created.Valid {
-			li.CreatedAt = created.Time
-		}
-		if updated.Valid {
-			li.UpdatedAt = updated.Time
-		}
-		out = append(out, &li)
-	}
-	return out, rows.Err()
-}
+// lineItemColumns is the column list shared by all line item SELECTs; keep in sync with scanLineItem.
+const lineItemColumns = `id, context_id, label, resource_id, resource_link_id, tag, score_maximum, start_at, end_at, grades_released, COALESCE(client_id, ''), created_at, updated_at`
 
-func (r *SQLiteRepo) GetLineItem(ctx context.Context, id int64, contextID string) (*sc.LineItem, error) {
-	row := r.db.QueryRowContext(ctx, `
-		SELECT id, context_id, label, resource_id, resource_link_id, tag, score_maximum, start_at, end_at, created_at, updated_at
-		FROM line_items WHERE id = ? AND context_id = ?`, id, contextID)
+// scanLineItem scans a row selected with lineItemColumns.
+func scanLineItem(row interface{ Scan(...any) error }) (*sc.LineItem, error) {
 	var li sc.LineItem
 	var start, end, created, updated sql.NullTime
-	if err := row.Scan(&li.ID, &li.ContextID, &li.Label, &li.ResourceID, &li.ResourceLinkID, &li.Tag, &li.ScoreMaximum, &start, &end, &created, &updated); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
+	var released sql.NullBool
+	if err := row.Scan(&li.ID, &li.ContextID, &li.Label, &li.ResourceID, &li.ResourceLinkID, &li.Tag, &li.ScoreMaximum, &start, &end, &released, &li.ClientID, &created, &updated); err != nil {
 		return nil, err
 	}
+	if released.Valid {
+		li.GradesReleased = &released.Bool
+	}
 	if start.Valid {
 		li.StartAt = &start.Time
 	}
@@ -217,12 +281,76 @@ func (r *SQLiteRepo) GetLineItem(ctx context.Context, id int64, contextID string
 	return &li, nil
 }
 
+// ListLineItemsPage returns one page of the context's line items matching f, ordered by id,
+// and the number of matching line items.
+func (r *SQLiteRepo) ListLineItemsPage(ctx context.Context, contextID string, f sc.LineItemFilter, offset, limit int) ([]*sc.LineItem, int, error) {
+	where := `context_id = ?`
+	args := []any{contextID}
+	if f.ResourceLinkID != "" {
+		where += ` AND resource_link_id = ?`
+		args = append(args, f.ResourceLinkID)
+	}
+	if f.ResourceID != "" {
+		where += ` AND resource_id = ?`
+		args = append(args, f.ResourceID)
+	}
+	if f.Tag != "" {
+		where += ` AND tag = ?`
+		args = append(args, f.Tag)
+	}
+	if f.OwnerClientID != "" {
+		owned := `client_id = ?`
+		args = append(args, f.OwnerClientID)
+		if len(f.OwnerResourceLinkIDs) > 0 {
+			owned += ` OR resource_link_id IN (?` + strings.Repeat(`, ?`, len(f.OwnerResourceLinkIDs)-1) + `)`
+			for _, id := range f.OwnerResourceLinkIDs {
+				args = append(args, id)
+			}
+		}
+		where += ` AND (` + owned + `)`
+	}
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM line_items WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT `+lineItemColumns+` FROM line_items WHERE `+where+` ORDER BY id ASC LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var out []*sc.LineItem
+	for rows.Next() {
+		li, err := scanLineItem(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, li)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return out, total, nil
+}
+
+func (r *SQLiteRepo) GetLineItem(ctx context.Context, id int64, contextID string) (*sc.LineItem, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+lineItemColumns+` FROM line_items WHERE id = ? AND context_id = ?`, id, contextID)
+	li, err := scanLineItem(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return li, nil
+}
+
 func (r *SQLiteRepo) UpdateLineItem(ctx context.Context, li *sc.LineItem) error {
 	now := time.Now().UTC()
 	_, err := r.db.ExecContext(ctx, `
-		UPDATE line_items SET label = ?, resource_id = ?, resource_link_id = ?, tag = ?, score_maximum = ?, start_at = ?, end_at = ?, updated_at = ?
+		UPDATE line_items SET label = ?, resource_id = ?, resource_link_id = ?, tag = ?, score_maximum = ?, start_at = ?, end_at = ?, grades_released = ?, updated_at = ?
 		WHERE id = ? AND context_id = ?
-	`, li.Label, li.ResourceID, li.ResourceLinkID, li.Tag, li.ScoreMaximum, nullableTime(li.StartAt), nullableTime(li.EndAt), now, li.ID, li.ContextID)
+	`, li.Label, li.ResourceID, li.ResourceLinkID, li.Tag, li.ScoreMaximum, nullableTime(li.StartAt), nullableTime(li.EndAt), nullableBool(li.GradesReleased), now, li.ID, li.ContextID)
 	if err == nil {
 		li.UpdatedAt = now
 	}
@@ -230,7 +358,12 @@ func (r *SQLiteRepo) UpdateLineItem(ctx context.Context, li *sc.LineItem) error
 }
 
 func (r *SQLiteRepo) DeleteLineItem(ctx context.Context, id int64, contextID string) error {
-	res, err := r.db.ExecContext(ctx, `DELETE FROM line_items WHERE id = ? AND context_id = ?`, id, contextID)
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	res, err := tx.ExecContext(ctx, `DELETE FROM line_items WHERE id = ? AND context_id = ?`, id, contextID)
 	if err != nil {
 		return err
 	}
@@ -241,59 +374,173 @@ func (r *SQLiteRepo) DeleteLineItem(ctx context.Context, id int64, contextID str
 	if n == 0 {
 		return sql.ErrNoRows
 	}
-	return nil
+	if _, err := tx.ExecContext(ctx, `DELETE FROM line_item_mappings WHERE line_item_id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *SQLiteRepo) UpsertResultFromScore(ctx context.Context, lineItemID int64, contextID string, s *sc.Score) error {
-	_, err := r.db.ExecContext(ctx, `
+func (r *SQLiteRepo) RecordScore(ctx context.Context, lineItemID int64, contextID, clientID string, s *sc.Score) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO scores (line_item_id, context_id, user_id, client_id, score_given, score_maximum, comment, timestamp, activity_progress, grading_progress, received_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, lineItemID, contextID, s.UserID, clientID, nullableFloat(s.ScoreGiven), nullableFloat(s.ScoreMaximum), s.Comment, s.Timestamp.UTC(), s.ActivityProgress, s.GradingProgress, time.Now().UTC()); err != nil {
+		return err
+	}
+	// Checked after the insert, which holds the write lock, so concurrent scores cannot both pass.
+	var current time.Time
+	err = tx.QueryRowContext(ctx, `SELECT timestamp FROM results WHERE line_item_id = ? AND context_id = ? AND user_id = ?`, lineItemID, contextID, s.UserID).Scan(&current)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	case s.Timestamp.Before(current):
+		return sc.ErrStaleScore
+	case s.Timestamp.Equal(current):
+		return sc.ErrDuplicateScoreTimestamp
+	}
+	// The result is derived from the user's latest history row, so the two cannot drift. A score
+	// without scoreGiven clears the grade, so its scoreMaximum is not carried into the result.
+	if _, err := tx.ExecContext(ctx, `
 		INSERT INTO results (line_item_id, context_id, user_id, result_score, result_maximum, comment, timestamp, activity_progress, grading_progress)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		SELECT line_item_id, context_id, user_id, score_given, CASE WHEN score_given IS NULL THEN NULL ELSE score_maximum END, comment, timestamp, activity_progress, grading_progress
+		FROM scores WHERE line_item_id = ? AND context_id = ? AND user_id = ?
+		ORDER BY timestamp DESC, id DESC LIMIT 1
 		ON CONFLICT(line_item_id, context_id, user_id)
 		DO UPDATE SET result_score = excluded.result_score, result_maximum = excluded.result_maximum, comment = excluded.comment, timestamp = excluded.timestamp, activity_progress = excluded.activity_progress, grading_progress = excluded.grading_progress
-	`, lineItemID, contextID, s.UserID, nullableFloat(s.ScoreGiven), nullableFloat(s.ScoreMaximum), s.Comment, s.Timestamp.UTC(), s.ActivityProgress, s.GradingProgress)
-	return err
+	`, lineItemID, contextID, s.UserID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLiteRepo) HasClientScores(ctx context.Context, clientID string) (bool, error) {
+	var n int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM (SELECT 1 FROM scores WHERE client_id = ? LIMIT 1)`, clientID).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
 }
 
-func (r *SQLiteRepo) ListResultsByLineItem(ctx context.Context, lineItemID int64, contextID string) ([]*sc.Result, error) {
+func (r *SQLiteRepo) ListScoreHistory(ctx context.Context, lineItemID int64, contextID, userID string, offset, limit int) ([]*sc.ScoreRecord, int, error) {
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM scores WHERE line_item_id = ? AND context_id = ? AND user_id = ?`, lineItemID, contextID, userID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
 	rows, err := r.db.QueryContext(ctx, `
-		SELECT user_id, result_score, result_maximum, comment, timestamp, activity_progress, grading_progress
-		FROM results WHERE line_item_id = ? AND context_id = ? ORDER BY user_id ASC`, lineItemID, contextID)
+		SELECT id, line_item_id, user_id, client_id, score_given, score_maximum, comment, timestamp, activity_progress, grading_progress, received_at
+		FROM scores WHERE line_item_id = ? AND context_id = ? AND user_id = ? ORDER BY id ASC LIMIT ? OFFSET ?`, lineItemID, contextID, userID, limit, offset)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
-	var out []*sc.Result
+	var out []*sc.ScoreRecord
 	for rows.Next() {
-		var rscore sc.Result
-		var score, max sql.NullFloat64
-		var ts time.Time
-		var comment sql.NullString
-		var act sql.NullString
-		var grd sql.NullString
-		if err := rows.Scan(&rscore.UserID, &score, &max, &comment, &ts, &act, &grd); err != nil {
-			return nil, err
+		var rec sc.ScoreRecord
+		var given, max sql.NullFloat64
+		var comment, act, grd sql.NullString
+		if err := rows.Scan(&rec.ID, &rec.LineItemID, &rec.UserID, &rec.ClientID, &given, &max, &comment, &rec.Timestamp, &act, &grd, &rec.ReceivedAt); err != nil {
+			return nil, 0, err
 		}
-		if score.Valid {
-			v := score.Float64
-			rscore.ResultScore = &v
+		if given.Valid {
+			v := given.Float64
+			rec.ScoreGiven = &v
 		}
 		if max.Valid {
 			v := max.Float64
-			rscore.ResultMaximum = &v
+			rec.ScoreMaximum = &v
 		}
-		if comment.Valid {
-			rscore.Comment = comment.String
-		}
-		rscore.Timestamp = ts
-		if act.Valid {
-			rscore.ActivityProgress = act.String
+		rec.Comment = comment.String
+		rec.ActivityProgress = act.String
+		rec.GradingProgress = grd.String
+		out = append(out, &rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return out, total, nil
+}
+
+// resultColumns is the column list shared by all result SELECTs; keep in sync with scanResult.
+const resultColumns = `id, line_item_id, user_id, result_score, result_maximum, comment, timestamp, activity_progress, grading_progress`
+
+// scanResult scans a row selected with resultColumns.
+func scanResult(row interface{ Scan(...any) error }) (*sc.Result, error) {
+	var rscore sc.Result
+	var score, max sql.NullFloat64
+	var ts time.Time
+	var comment sql.NullString
+	var act sql.NullString
+	var grd sql.NullString
+	if err := row.Scan(&rscore.ID, &rscore.LineItemID, &rscore.UserID, &score, &max, &comment, &ts, &act, &grd); err != nil {
+		return nil, err
+	}
+	if score.Valid {
+		v := score.Float64
+		rscore.ResultScore = &v
+	}
+	if max.Valid {
+		v := max.Float64
+		rscore.ResultMaximum = &v
+	}
+	if comment.Valid {
+		rscore.Comment = comment.String
+	}
+	rscore.Timestamp = ts
+	if act.Valid {
+		rscore.ActivityProgress = act.String
+	}
+	if grd.Valid {
+		rscore.GradingProgress = grd.String
+	}
+	return &rscore, nil
+}
+
+func (r *SQLiteRepo) ListResultsPage(ctx context.Context, lineItemID int64, contextID, userID string, offset, limit int) ([]*sc.Result, int, error) {
+	where := `line_item_id = ? AND context_id = ?`
+	args := []any{lineItemID, contextID}
+	if userID != "" {
+		where += ` AND user_id = ?`
+		args = append(args, userID)
+	}
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM results WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT `+resultColumns+` FROM results WHERE `+where+` ORDER BY user_id ASC LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var out []*sc.Result
+	for rows.Next() {
+		rscore, err := scanResult(rows)
+		if err != nil {
+			return nil, 0, err
 		}
-		if grd.Valid {
-			rscore.GradingProgress = grd.String
+		out = append(out, rscore)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return out, total, nil
+}
+
+func (r *SQLiteRepo) GetResult(ctx context.Context, id, lineItemID int64, contextID string) (*sc.Result, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+resultColumns+` FROM results WHERE id = ? AND line_item_id = ? AND context_id = ?`, id, lineItemID, contextID)
+	rscore, err := scanResult(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
 		}
-		out = append(out, &rscore)
+		return nil, err
 	}
-	return out, rows.Err()
+	return rscore, nil
 }
 
 func nullableTime(t *time.Time) any {
@@ -303,6 +550,13 @@ func nullableTime(t *time.Time) any {
 	return t.UTC()
 }
 
+func nullableBool(b *bool) any {
+	if b == nil {
+		return nil
+	}
+	return *b
+}
+
 func nullableFloat(f *float64) any {
 	if f == nil {
 		return nil
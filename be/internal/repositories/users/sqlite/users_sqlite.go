@@ -0,0 +1,156 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+
+	ur "github.com/quipper/poc/lti/be/pkg/repositories/users"
+)
+
+type SQLiteRepo struct{ db *sql.DB }
+
+// Ensure interface compliance
+var _ ur.Repository = (*SQLiteRepo)(nil)
+
+func NewSQLiteRepo(path string) (*SQLiteRepo, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := initSchema(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &SQLiteRepo{db: db}, nil
+}
+
+func (s *SQLiteRepo) Disconnect() { _ = s.db.Close() }
+
+func initSchema(db *sql.DB) error {
+	var existed int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'`).Scan(&existed)
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS users (
+	  id TEXT PRIMARY KEY,
+	  email TEXT NOT NULL,
+	  name TEXT,
+	  given_name TEXT,
+	  family_name TEXT,
+	  roles_json TEXT,
+	  created_at TIMESTAMP NOT NULL,
+	  updated_at TIMESTAMP NOT NULL
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users(email COLLATE NOCASE);
+	`)
+	if err != nil || existed > 0 {
+		return err
+	}
+	// Seed the user the sandbox FE launches as (its login_hint) so launches work on a fresh database.
+	now := time.Now().UTC()
+	_, err = db.Exec(`INSERT INTO users (id, email, given_name, family_name, roles_json, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		uuid.NewString(), "haries@efrika.net", "Haries", "Efrika", `["http://purl.imsglobal.org/vocab/lis/v2/institution/person#Instructor"]`, now, now)
+	return err
+}
+
+const userColumns = `id, email, COALESCE(name, ''), COALESCE(given_name, ''), COALESCE(family_name, ''), COALESCE(roles_json, ''), created_at, updated_at`
+
+func scanUser(row interface{ Scan(...any) error }) (*ur.User, error) {
+	var u ur.User
+	var rolesJSON string
+	if err := row.Scan(&u.ID, &u.Email, &u.Name, &u.GivenName, &u.FamilyName, &rolesJSON, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if rolesJSON != "" {
+		_ = json.Unmarshal([]byte(rolesJSON), &u.Roles)
+	}
+	return &u, nil
+}
+
+func (s *SQLiteRepo) ListUsers(ctx context.Context) ([]*ur.User, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+userColumns+` FROM users ORDER BY email COLLATE NOCASE ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*ur.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLiteRepo) GetUserByID(ctx context.Context, id string) (*ur.User, error) {
+	u, err := scanUser(s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return u, err
+}
+
+func (s *SQLiteRepo) GetUserByEmail(ctx context.Context, email string) (*ur.User, error) {
+	u, err := scanUser(s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE email = ? COLLATE NOCASE`, strings.TrimSpace(email)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return u, err
+}
+
+func (s *SQLiteRepo) CreateUser(ctx context.Context, u *ur.User) error {
+	if u.ID == "" {
+		u.ID = uuid.NewString()
+	}
+	now := time.Now().UTC()
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO users (id, email, name, given_name, family_name, roles_json, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, u.ID, strings.TrimSpace(u.Email), u.Name, u.GivenName, u.FamilyName, rolesJSON(u.Roles), now, now)
+	if err != nil {
+		return err
+	}
+	u.CreatedAt, u.UpdatedAt = now, now
+	return nil
+}
+
+func (s *SQLiteRepo) UpdateUser(ctx context.Context, u *ur.User) (bool, error) {
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, `
+	UPDATE users SET email = ?, name = ?, given_name = ?, family_name = ?, roles_json = ?, updated_at = ?
+	WHERE id = ?
+	`, strings.TrimSpace(u.Email), u.Name, u.GivenName, u.FamilyName, rolesJSON(u.Roles), now, u.ID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	u.UpdatedAt = now
+	return true, nil
+}
+
+func (s *SQLiteRepo) DeleteUser(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, id)
+	return err
+}
+
+func rolesJSON(roles []string) string {
+	if len(roles) == 0 {
+		return "[]"
+	}
+	b, err := json.Marshal(roles)
+	if err != nil {
+		return "[]"
+	}
+	return string(b)
+}
@@ -3,7 +3,9 @@ package validation
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"strings"
 	"time"
 
 	vrepo "github.com/quipper/poc/lti/be/pkg/repositories/validation"
@@ -40,17 +42,39 @@ CREATE TABLE IF NOT EXISTS client_assertion_jtis (
 );
 CREATE INDEX IF NOT EXISTS idx_jtis_expires_at ON client_assertion_jtis(expires_at);
 
+CREATE TABLE IF NOT EXISTS issued_tokens (
+    jti TEXT PRIMARY KEY,
+    client_id TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    deployment_id TEXT,
+    issued_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_issued_tokens_client_id ON issued_tokens(client_id);
+CREATE INDEX IF NOT EXISTS idx_issued_tokens_expires_at ON issued_tokens(expires_at);
+
 CREATE TABLE IF NOT EXISTS oidc_states (
     state TEXT PRIMARY KEY,
     client_id TEXT,
     target_link_uri TEXT,
     resource_link_id TEXT,
     context_id TEXT,
+    user_id TEXT,
+    deployment_id TEXT,
+    deep_linking_json TEXT,
     expires_at TIMESTAMP NOT NULL,
     used INTEGER NOT NULL DEFAULT 0,
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 CREATE INDEX IF NOT EXISTS idx_states_expires_at ON oidc_states(expires_at);
+
+CREATE TABLE IF NOT EXISTS registration_tokens (
+    token TEXT PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL,
+    used INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
 `)
 	if err != nil {
 		return err
@@ -58,6 +82,11 @@ CREATE INDEX IF NOT EXISTS idx_states_expires_at ON oidc_states(expires_at);
 	// Best-effort: add columns for existing databases; ignore error if exists
 	_, _ = db.Exec(`ALTER TABLE oidc_states ADD COLUMN context_id TEXT`)
 	_, _ = db.Exec(`ALTER TABLE oidc_states ADD COLUMN resource_link_id TEXT`)
+	_, _ = db.Exec(`ALTER TABLE oidc_states ADD COLUMN user_id TEXT`)
+	_, _ = db.Exec(`ALTER TABLE oidc_states ADD COLUMN deployment_id TEXT`)
+	_, _ = db.Exec(`ALTER TABLE oidc_states ADD COLUMN deep_linking_json TEXT`)
+	// Deep linking requests are now deep linking sessions in the tools database.
+	_, _ = db.Exec(`DROP TABLE IF EXISTS deep_link_requests`)
 	return nil
 }
 
@@ -92,7 +121,89 @@ func (r *SQLiteRepo) TryUseClientAssertionJTI(ctx context.Context, jti string, c
 	return true, nil
 }
 
-func (r *SQLiteRepo) CreateOIDCState(ctx context.Context, state string, clientID string, targetLinkURI string, contextID string, resourceLinkID string, exp time.Time) error {
+func (r *SQLiteRepo) RecordIssuedToken(ctx context.Context, t *vrepo.IssuedToken) error {
+	if t.JTI == "" {
+		return errors.New("empty jti")
+	}
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Cleanup expired; a token past its expiry is rejected on its own
+	if _, _ = tx.ExecContext(ctx, "DELETE FROM issued_tokens WHERE expires_at < ?", time.Now().UTC()); false {
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO issued_tokens (jti, client_id, scope, deployment_id, issued_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		t.JTI, t.ClientID, t.Scope, t.DeploymentID, t.IssuedAt.UTC(), t.ExpiresAt.UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLiteRepo) GetIssuedToken(ctx context.Context, jti string) (*vrepo.IssuedToken, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT jti, client_id, scope, COALESCE(deployment_id, ''), issued_at, expires_at, revoked_at FROM issued_tokens WHERE jti = ?`, jti)
+	var t vrepo.IssuedToken
+	var revoked sql.NullTime
+	if err := row.Scan(&t.JTI, &t.ClientID, &t.Scope, &t.DeploymentID, &t.IssuedAt, &t.ExpiresAt, &revoked); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if revoked.Valid {
+		t.RevokedAt = &revoked.Time
+	}
+	return &t, nil
+}
+
+func (r *SQLiteRepo) RevokeIssuedToken(ctx context.Context, jti string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `UPDATE issued_tokens SET revoked_at = ? WHERE jti = ? AND revoked_at IS NULL`, time.Now().UTC(), jti)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (r *SQLiteRepo) RevokeClientTokens(ctx context.Context, clientID string) (int64, error) {
+	now := time.Now().UTC()
+	res, err := r.db.ExecContext(ctx, `UPDATE issued_tokens SET revoked_at = ? WHERE client_id = ? AND revoked_at IS NULL AND expires_at > ?`, now, clientID, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *SQLiteRepo) ListClientTokenScopes(ctx context.Context, clientID string) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT DISTINCT scope FROM issued_tokens WHERE client_id = ?`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	seen := map[string]bool{}
+	for rows.Next() {
+		var scope string
+		if err := rows.Scan(&scope); err != nil {
+			return nil, err
+		}
+		for _, s := range strings.Fields(scope) {
+			if !seen[s] {
+				seen[s] = true
+				out = append(out, s)
+			}
+		}
+	}
+	return out, rows.Err()
+}
+
+func (r *SQLiteRepo) CreateOIDCState(ctx context.Context, state string, data *vrepo.OIDCState, exp time.Time) error {
 	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
 	if err != nil {
 		return err
@@ -103,39 +214,98 @@ func (r *SQLiteRepo) CreateOIDCState(ctx context.Context, state string, clientID
 	if _, _ = tx.ExecContext(ctx, "DELETE FROM oidc_states WHERE expires_at < CURRENT_TIMESTAMP OR used = 1"); false {
 	}
 
-	_, err = tx.ExecContext(ctx, `INSERT INTO oidc_states (state, client_id, target_link_uri, resource_link_id, context_id, expires_at) VALUES (?, ?, ?, ?, ?, ?)`, state, clientID, targetLinkURI, resourceLinkID, contextID, exp.UTC())
+	var deepLinking any
+	if data.DeepLinking != nil {
+		b, err := json.Marshal(data.DeepLinking)
+		if err != nil {
+			return err
+		}
+		deepLinking = string(b)
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO oidc_states (state, client_id, target_link_uri, resource_link_id, context_id, user_id, deployment_id, deep_linking_json, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		state, data.ClientID, data.TargetLinkURI, data.ResourceLinkID, data.ContextID, data.UserID, data.DeploymentID, deepLinking, exp.UTC())
 	if err != nil {
 		return err
 	}
 	return tx.Commit()
 }
 
-func (r *SQLiteRepo) ConsumeOIDCState(ctx context.Context, state string) (clientID string, targetLinkURI string, resourceLinkID string, contextID string, ok bool, err error) {
+func (r *SQLiteRepo) ConsumeOIDCState(ctx context.Context, state string) (*vrepo.OIDCState, bool, error) {
 	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
 	if err != nil {
-		return "", "", "", "", false, err
+		return nil, false, err
 	}
 	defer func() { _ = tx.Rollback() }()
 
 	// Load
-	row := tx.QueryRowContext(ctx, `SELECT client_id, target_link_uri, resource_link_id, context_id, expires_at, used FROM oidc_states WHERE state = ?`, state)
+	row := tx.QueryRowContext(ctx, `SELECT COALESCE(client_id, ''), COALESCE(target_link_uri, ''), COALESCE(resource_link_id, ''), COALESCE(context_id, ''), COALESCE(user_id, ''), COALESCE(deployment_id, ''), COALESCE(deep_linking_json, ''), expires_at, used FROM oidc_states WHERE state = ?`, state)
+	var data vrepo.OIDCState
+	var deepLinking string
 	var exp time.Time
 	var used int
-	if err := row.Scan(&clientID, &targetLinkURI, &resourceLinkID, &contextID, &exp, &used); err != nil {
+	if err := row.Scan(&data.ClientID, &data.TargetLinkURI, &data.ResourceLinkID, &data.ContextID, &data.UserID, &data.DeploymentID, &deepLinking, &exp, &used); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", "", "", "", false, nil
+			return nil, false, nil
 		}
-		return "", "", "", "", false, err
+		return nil, false, err
 	}
 	if used == 1 || time.Now().After(exp) {
-		return "", "", "", "", false, nil
+		return nil, false, nil
+	}
+	if deepLinking != "" {
+		data.DeepLinking = &vrepo.DeepLinkSettings{}
+		_ = json.Unmarshal([]byte(deepLinking), data.DeepLinking)
 	}
 	// Mark used
 	if _, err := tx.ExecContext(ctx, `UPDATE oidc_states SET used = 1 WHERE state = ?`, state); err != nil {
-		return "", "", "", "", false, err
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return &data, true, nil
+}
+
+func (r *SQLiteRepo) CreateRegistrationToken(ctx context.Context, token string, exp time.Time) error {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Cleanup expired
+	if _, _ = tx.ExecContext(ctx, "DELETE FROM registration_tokens WHERE expires_at < CURRENT_TIMESTAMP OR used = 1"); false {
+	}
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO registration_tokens (token, expires_at) VALUES (?, ?)`, token, exp.UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLiteRepo) ConsumeRegistrationToken(ctx context.Context, token string) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var exp time.Time
+	var used int
+	if err := tx.QueryRowContext(ctx, `SELECT expires_at, used FROM registration_tokens WHERE token = ?`, token).Scan(&exp, &used); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	if used == 1 || time.Now().After(exp) {
+		return false, nil
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE registration_tokens SET used = 1 WHERE token = ?`, token); err != nil {
+		return false, err
 	}
 	if err := tx.Commit(); err != nil {
-		return "", "", "", "", false, err
+		return false, err
 	}
-	return clientID, targetLinkURI, resourceLinkID, contextID, true, nil
+	return true, nil
 }
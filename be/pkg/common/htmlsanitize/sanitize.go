@@ -0,0 +1,20 @@
+// Package htmlsanitize reduces untrusted HTML fragments to a user-generated-content allowlist
+// of formatting tags and attributes, for content items returned by tools.
+package htmlsanitize
+
+import "github.com/microcosm-cc/bluemonday"
+
+// policy is bluemonday's UGC policy; links may keep target and always get rel="nofollow noreferrer"
+// (plus noopener for target="_blank").
+var policy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("target").Matching(bluemonday.SpaceSeparatedTokens).OnElements("a")
+	p.RequireNoReferrerOnLinks(true)
+	return p
+}()
+
+// Sanitize returns s with disallowed elements, attributes, comments and unsafe URLs removed.
+// The result is safe to embed in a page.
+func Sanitize(s string) string {
+	return policy.Sanitize(s)
+}
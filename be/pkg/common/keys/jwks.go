@@ -1,117 +1,518 @@
 package keys
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lestrrat-go/jwx/v2/jwa"
 	jwk "github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/quipper/poc/lti/be/pkg/common/logger"
+	keysRepo "github.com/quipper/poc/lti/be/pkg/repositories/keys"
 )
 
-// manager lazily generates and holds a platform RSA key and JWKS for signing id_tokens.
-// For a PoC we keep it in-memory. In production, persist and allow rotation.
+// The platform holds a key ring: one active signing key per enabled algorithm
+// plus earlier keys that were rotated out but are still published in the JWKS
+// for a grace window, so tools that cached our JWKS keep verifying tokens signed
+// before the rotation. When a KeyStore is configured the ring is loaded from and
+// persisted to it.
 var (
-	once       sync.Once
+	once         sync.Once
+	mu           sync.RWMutex
+	ring         []*entry                          // all published keys, newest first
+	active       map[jwa.SignatureAlgorithm]*entry // signing key per enabled algorithm
+	enabledAlgs  = []jwa.SignatureAlgorithm{jwa.RS256}
 	platformJWKS jwk.Set
-	platformKid  string
-	platformKey  *rsa.PrivateKey
+	gracePeriod  = 24 * time.Hour
+	store        keysRepo.KeyStore
 )
 
-// Init ensures the JWKS is available.
+// supportedAlgs lists the signing algorithms the platform can hold keys for.
+var supportedAlgs = map[jwa.SignatureAlgorithm]bool{
+	jwa.RS256: true,
+	jwa.PS256: true,
+	jwa.ES256: true,
+}
+
+var (
+	// ErrActiveKey is returned when trying to retire an active signing key.
+	ErrActiveKey = errors.New("cannot retire an active signing key; rotate to a new key first")
+	// ErrUnsupportedAlg is returned for algorithms that are not enabled on this platform.
+	ErrUnsupportedAlg = errors.New("signing algorithm not enabled")
+)
+
+// entry is a single platform key in the ring.
+type entry struct {
+	kid       string
+	alg       jwa.SignatureAlgorithm
+	key       crypto.Signer // *rsa.PrivateKey or *ecdsa.PrivateKey
+	createdAt time.Time
+	retiredAt time.Time // zero while the key is active
+	expiresAt time.Time // when the key leaves the JWKS; zero while active
+}
+
+// KeyInfo describes a key in the ring without exposing private material.
+type KeyInfo struct {
+	Kid       string     `json:"kid"`
+	Alg       string     `json:"alg"`
+	Active    bool       `json:"active"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // when a retired key leaves the JWKS
+}
+
+// SetStore configures persistent storage for platform keys. It must be called
+// before Init; otherwise keys come from env vars or are generated per process.
+func SetStore(s keysRepo.KeyStore) {
+	store = s
+}
+
+// Init ensures the key ring and JWKS are available.
 func Init() error {
 	var initErr error
 	once.Do(func() {
-		// Try to read a KID from env, else random UUID.
-		kid := os.Getenv("PLATFORM_KID")
-		if kid == "" {
-			kid = uuid.NewString()
-		}
-		platformKid = kid
-
-		// Prefer loading private key from environment (CI/CD-provided)
-		var key *rsa.PrivateKey
-		if b64 := os.Getenv("PLATFORM_PRIVATE_KEY_B64"); b64 != "" {
-			der, err := base64.StdEncoding.DecodeString(b64)
-			if err == nil {
-				if block, _ := pem.Decode(der); block != nil {
-					if k, err2 := x509.ParsePKCS1PrivateKey(block.Bytes); err2 == nil {
-						key = k
-					} else if pkcs8, err3 := x509.ParsePKCS8PrivateKey(block.Bytes); err3 == nil {
-						if rk, ok := pkcs8.(*rsa.PrivateKey); ok {
-							key = rk
-						}
-					}
-				}
-			}
+		initErr = load(context.Background())
+	})
+	return initErr
+}
+
+func load(ctx context.Context) error {
+	if v := os.Getenv("PLATFORM_KEY_GRACE_PERIOD"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid PLATFORM_KEY_GRACE_PERIOD: %w", err)
 		}
-		if key == nil {
-			if pemStr := os.Getenv("PLATFORM_PRIVATE_KEY_PEM"); pemStr != "" {
-				if block, _ := pem.Decode([]byte(pemStr)); block != nil {
-					if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
-						key = k
-					} else if pkcs8, err2 := x509.ParsePKCS8PrivateKey(block.Bytes); err2 == nil {
-						if rk, ok := pkcs8.(*rsa.PrivateKey); ok {
-							key = rk
-						}
-					}
-				}
+		gracePeriod = d
+	}
+	// PLATFORM_SIGNING_ALGS, e.g. "RS256,ES256". The first one is the default
+	// used for access tokens and tools that do not pick an algorithm.
+	if v := os.Getenv("PLATFORM_SIGNING_ALGS"); v != "" {
+		var algs []jwa.SignatureAlgorithm
+		for _, p := range strings.Split(v, ",") {
+			alg := jwa.SignatureAlgorithm(strings.TrimSpace(p))
+			if alg == "" {
+				continue
 			}
+			if !supportedAlgs[alg] {
+				return fmt.Errorf("unsupported algorithm %q in PLATFORM_SIGNING_ALGS", alg)
+			}
+			algs = append(algs, alg)
+		}
+		if len(algs) == 0 {
+			return errors.New("PLATFORM_SIGNING_ALGS is empty")
 		}
-		// Fallback: generate a 2048-bit RSA key for dev.
-		if key == nil {
-			gen, err := rsa.GenerateKey(rand.Reader, 2048)
+		enabledAlgs = algs
+	}
+
+	var entries []*entry
+	if store != nil {
+		stored, err := store.ListKeys(ctx)
+		if err != nil {
+			return fmt.Errorf("load keys from store: %w", err)
+		}
+		for _, k := range stored {
+			e, err := entryFromStored(k)
 			if err != nil {
-				initErr = err
-				return
+				return fmt.Errorf("load key %s: %w", k.Kid, err)
+			}
+			entries = append(entries, e)
+		}
+	}
+	// Newest first; the newest non-retired key of each enabled algorithm is active.
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].createdAt.After(entries[j].createdAt) })
+	act := map[jwa.SignatureAlgorithm]*entry{}
+	for _, e := range entries {
+		if !e.retiredAt.IsZero() {
+			continue
+		}
+		if act[e.alg] == nil && isEnabled(e.alg) {
+			act[e.alg] = e
+			continue
+		}
+		// Only one key signs per algorithm; retire stale or disabled ones so they age out of the JWKS.
+		e.retiredAt = time.Now().UTC()
+		e.expiresAt = e.retiredAt.Add(gracePeriod)
+		if err := store.RetireKey(ctx, e.kid, e.retiredAt, e.expiresAt); err != nil {
+			return fmt.Errorf("retire stale key %s: %w", e.kid, err)
+		}
+	}
+
+	for _, alg := range enabledAlgs {
+		if act[alg] != nil {
+			if alg == jwa.RS256 && (os.Getenv("PLATFORM_PRIVATE_KEY_B64") != "" || os.Getenv("PLATFORM_PRIVATE_KEY_PEM") != "") {
+				logger.Warn("keys: key store already holds an active RS256 key (kid=%s); ignoring PLATFORM_PRIVATE_KEY_* env", act[alg].kid)
+			}
+			continue
+		}
+		e, err := bootstrapKey(alg)
+		if err != nil {
+			return err
+		}
+		if store != nil {
+			if err := persist(ctx, e); err != nil {
+				return fmt.Errorf("persist key %s: %w", e.kid, err)
 			}
-			key = gen
-			// Print helpers so the operator can capture and persist the key.
+			logger.Info("keys: created platform signing key kid=%s alg=%s", e.kid, alg)
+		}
+		act[alg] = e
+		entries = append([]*entry{e}, entries...)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	ring = entries
+	active = act
+	return rebuildLocked()
+}
+
+func isEnabled(alg jwa.SignatureAlgorithm) bool {
+	for _, a := range enabledAlgs {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// bootstrapKey returns the first signing key for alg. For RS256 the key comes
+// from env vars when provided (CI/CD or migration into a store); otherwise a
+// key is freshly generated.
+func bootstrapKey(alg jwa.SignatureAlgorithm) (*entry, error) {
+	if alg != jwa.RS256 {
+		key, err := generateKey(alg)
+		if err != nil {
+			return nil, err
+		}
+		return &entry{kid: uuid.NewString(), alg: alg, key: key, createdAt: time.Now().UTC()}, nil
+	}
+
+	// Try to read a KID from env, else random UUID.
+	kid := os.Getenv("PLATFORM_KID")
+	if kid == "" {
+		kid = uuid.NewString()
+	}
+
+	var key *rsa.PrivateKey
+	if b64 := os.Getenv("PLATFORM_PRIVATE_KEY_B64"); b64 != "" {
+		if der, err := base64.StdEncoding.DecodeString(b64); err == nil {
+			key, _ = parsePrivatePEM(der).(*rsa.PrivateKey)
+		}
+	}
+	if key == nil {
+		if pemStr := os.Getenv("PLATFORM_PRIVATE_KEY_PEM"); pemStr != "" {
+			key, _ = parsePrivatePEM([]byte(pemStr)).(*rsa.PrivateKey)
+		}
+	}
+	if key == nil {
+		gen, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		key = gen
+		if store == nil {
+			// No store: print helpers so the operator can capture and persist the key.
 			block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(gen)}
 			pemBytes := pem.EncodeToMemory(block)
 			b64 := base64.StdEncoding.EncodeToString(pemBytes)
-			fmt.Println("[keys] Generated ephemeral RSA key (dev mode). To persist, set one of:")
+			fmt.Println("[keys] Generated ephemeral RSA key (dev mode). To persist, configure a key store or set one of:")
 			fmt.Printf("export PLATFORM_PRIVATE_KEY_PEM='%s'\n", string(pemBytes))
 			fmt.Printf("export PLATFORM_PRIVATE_KEY_B64='%s'\n", b64)
 			fmt.Printf("export PLATFORM_KID='%s'\n", kid)
 		}
-		platformKey = key
+	}
+	return &entry{kid: kid, alg: jwa.RS256, key: key, createdAt: time.Now().UTC()}, nil
+}
 
-		jwkKey, err := jwk.FromRaw(&key.PublicKey)
-		if err != nil {
-			initErr = err
-			return
+// generateKey creates a new private key suitable for alg.
+func generateKey(alg jwa.SignatureAlgorithm) (crypto.Signer, error) {
+	switch alg {
+	case jwa.RS256, jwa.PS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case jwa.ES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, ErrUnsupportedAlg
+	}
+}
+
+// parsePrivatePEM decodes a PEM block holding a PKCS#1 RSA, SEC1 EC or PKCS#8 private key.
+// Returns nil when the data is not a supported private key.
+func parsePrivatePEM(data []byte) crypto.Signer {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k
+	}
+	if k, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return k
+	}
+	if pkcs8, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		switch k := pkcs8.(type) {
+		case *rsa.PrivateKey:
+			return k
+		case *ecdsa.PrivateKey:
+			return k
 		}
-		_ = jwkKey.Set("kid", kid)
-		_ = jwkKey.Set("alg", "RS256")
-		_ = jwkKey.Set("use", "sig")
-		_ = jwkKey.Set("kty", "RSA")
+	}
+	return nil
+}
 
-		set := jwk.NewSet()
-		set.AddKey(jwkKey)
-		platformJWKS = set
+func entryFromStored(k *keysRepo.Key) (*entry, error) {
+	key := parsePrivatePEM([]byte(k.PrivateKeyPEM))
+	if key == nil {
+		return nil, errors.New("unsupported or invalid private key PEM")
+	}
+	e := &entry{kid: k.Kid, alg: jwa.SignatureAlgorithm(k.Alg), key: key, createdAt: k.CreatedAt}
+	if k.RetiredAt != nil {
+		e.retiredAt = *k.RetiredAt
+	}
+	if k.ExpiresAt != nil {
+		e.expiresAt = *k.ExpiresAt
+	} else if k.RetiredAt != nil {
+		e.expiresAt = k.RetiredAt.Add(gracePeriod)
+	}
+	return e, nil
+}
+
+func persist(ctx context.Context, e *entry) error {
+	der, err := x509.MarshalPKCS8PrivateKey(e.key)
+	if err != nil {
+		return err
+	}
+	return store.CreateKey(ctx, &keysRepo.Key{
+		Kid:           e.kid,
+		Alg:           e.alg.String(),
+		PrivateKeyPEM: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		CreatedAt:     e.createdAt,
 	})
-	return initErr
 }
 
-// JWKSJSON returns the JWKS as JSON bytes.
+// rebuildLocked drops expired keys and regenerates the published JWKS.
+// Caller must hold mu for writing.
+func rebuildLocked() error {
+	now := time.Now()
+	kept := ring[:0]
+	for _, e := range ring {
+		if active[e.alg] != e && !e.expiresAt.IsZero() && !now.Before(e.expiresAt) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	ring = kept
+
+	set := jwk.NewSet()
+	for _, e := range ring {
+		pub, err := jwk.FromRaw(e.key.Public())
+		if err != nil {
+			return err
+		}
+		_ = pub.Set(jwk.KeyIDKey, e.kid)
+		_ = pub.Set(jwk.AlgorithmKey, e.alg)
+		_ = pub.Set(jwk.KeyUsageKey, "sig")
+		if err := set.AddKey(pub); err != nil {
+			return err
+		}
+	}
+	platformJWKS = set
+	return nil
+}
+
+// JWKSJSON returns the JWKS as JSON bytes. It includes the active keys and any
+// rotated-out keys still within the grace period, each advertising its alg.
 func JWKSJSON() ([]byte, error) {
 	if err := Init(); err != nil {
 		return nil, err
 	}
+	mu.RLock()
+	defer mu.RUnlock()
 	return json.Marshal(platformJWKS)
 }
 
-// PrivateKey returns the platform signing key (PoC use).
-func PrivateKey() *rsa.PrivateKey {
-	return platformKey
+// PublicKeySet returns the published public keys for verifying platform-issued tokens.
+// Tokens must carry a kid header matching a key still in the ring.
+func PublicKeySet() (jwk.Set, error) {
+	if err := Init(); err != nil {
+		return nil, err
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	return platformJWKS, nil
+}
+
+// DefaultAlg returns the algorithm used when a caller does not ask for one.
+func DefaultAlg() jwa.SignatureAlgorithm {
+	return enabledAlgs[0]
+}
+
+// EnabledAlgs returns the signing algorithms this platform holds active keys for.
+func EnabledAlgs() []string {
+	out := make([]string, 0, len(enabledAlgs))
+	for _, a := range enabledAlgs {
+		out = append(out, a.String())
+	}
+	return out
+}
+
+// IsEnabled reports whether alg can be used for signing.
+func IsEnabled(alg string) bool {
+	return isEnabled(jwa.SignatureAlgorithm(alg))
+}
+
+// SigningKey returns the active private key for alg as a JWK with kid and alg set,
+// ready to be passed to jwt.Sign. An empty alg selects DefaultAlg.
+func SigningKey(alg jwa.SignatureAlgorithm) (jwk.Key, error) {
+	if err := Init(); err != nil {
+		return nil, err
+	}
+	if alg == "" {
+		alg = DefaultAlg()
+	}
+	mu.RLock()
+	e := active[alg]
+	mu.RUnlock()
+	if e == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlg, alg)
+	}
+	key, err := jwk.FromRaw(e.key)
+	if err != nil {
+		return nil, err
+	}
+	_ = key.Set(jwk.KeyIDKey, e.kid)
+	_ = key.Set(jwk.AlgorithmKey, e.alg)
+	return key, nil
+}
+
+// Kid returns the active key id for the default algorithm.
+func Kid() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if e := active[DefaultAlg()]; e != nil {
+		return e.kid
+	}
+	return ""
+}
+
+// List returns the keys currently in the ring, newest first.
+func List() ([]KeyInfo, error) {
+	if err := Init(); err != nil {
+		return nil, err
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	out := make([]KeyInfo, 0, len(ring))
+	for _, e := range ring {
+		info := KeyInfo{Kid: e.kid, Alg: e.alg.String(), Active: active[e.alg] == e, CreatedAt: e.createdAt}
+		if !e.retiredAt.IsZero() {
+			retired := e.retiredAt
+			info.RetiredAt = &retired
+		}
+		if !e.expiresAt.IsZero() {
+			expires := e.expiresAt
+			info.ExpiresAt = &expires
+		}
+		out = append(out, info)
+	}
+	return out, nil
 }
 
-// Kid returns current key id.
-func Kid() string { return platformKid }
+// Rotate generates a new active signing key for alg (DefaultAlg when empty) and
+// returns its kid. The previous key stays published until the grace period elapses.
+func Rotate(ctx context.Context, alg jwa.SignatureAlgorithm) (string, error) {
+	if err := Init(); err != nil {
+		return "", err
+	}
+	if alg == "" {
+		alg = DefaultAlg()
+	}
+	if !isEnabled(alg) {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedAlg, alg)
+	}
+	gen, err := generateKey(alg)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	next := &entry{kid: uuid.NewString(), alg: alg, key: gen, createdAt: now}
+
+	mu.Lock()
+	defer mu.Unlock()
+	prev := active[alg]
+	if store != nil {
+		if err := persist(ctx, next); err != nil {
+			return "", err
+		}
+		if prev != nil {
+			if err := store.RetireKey(ctx, prev.kid, now, now.Add(gracePeriod)); err != nil && !errors.Is(err, keysRepo.ErrNotFound) {
+				return "", err
+			}
+		}
+	}
+	if prev != nil {
+		prev.retiredAt = now
+		prev.expiresAt = now.Add(gracePeriod)
+	}
+	active[alg] = next
+	ring = append([]*entry{next}, ring...)
+	if err := rebuildLocked(); err != nil {
+		return "", err
+	}
+	return next.kid, nil
+}
+
+// Retire removes a rotated-out key from the JWKS immediately instead of waiting
+// for its grace period, e.g. when the key is suspected to be compromised.
+// Returns keysRepo.ErrNotFound for kids not in the ring and ErrActiveKey for active keys.
+func Retire(ctx context.Context, kid string) error {
+	if err := Init(); err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, e := range ring {
+		if e.kid != kid {
+			continue
+		}
+		if active[e.alg] == e {
+			return ErrActiveKey
+		}
+		now := time.Now().UTC()
+		if e.retiredAt.IsZero() {
+			e.retiredAt = now
+		}
+		if store != nil {
+			if err := store.RetireKey(ctx, kid, e.retiredAt, now); err != nil {
+				return err
+			}
+		}
+		e.expiresAt = now
+		return rebuildLocked()
+	}
+	return keysRepo.ErrNotFound
+}
+
+// Prune removes rotated-out keys whose grace period has elapsed.
+func Prune() error {
+	if err := Init(); err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return rebuildLocked()
+}
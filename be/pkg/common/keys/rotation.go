@@ -0,0 +1,45 @@
+package keys
+
+import (
+	"context"
+	"time"
+
+	"github.com/quipper/poc/lti/be/pkg/common/logger"
+)
+
+// StartRotation rotates the active signing key of every enabled algorithm each interval until ctx is done.
+// Expired keys are pruned more often so the JWKS shrinks soon after the grace window.
+func StartRotation(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	pruneEvery := interval
+	if gracePeriod > 0 && gracePeriod < pruneEvery {
+		pruneEvery = gracePeriod
+	}
+	go func() {
+		rotateTicker := time.NewTicker(interval)
+		pruneTicker := time.NewTicker(pruneEvery)
+		defer rotateTicker.Stop()
+		defer pruneTicker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-rotateTicker.C:
+				for _, alg := range enabledAlgs {
+					kid, err := Rotate(ctx, alg)
+					if err != nil {
+						logger.Error("keys: scheduled rotation failed for %s: %v", alg, err)
+						continue
+					}
+					logger.Info("keys: rotated %s signing key, active kid=%s", alg, kid)
+				}
+			case <-pruneTicker.C:
+				if err := Prune(); err != nil {
+					logger.Error("keys: prune failed: %v", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,112 @@
+package ltiroles
+
+import (
+	"os"
+	"strings"
+
+	"github.com/quipper/poc/lti/be/pkg/common/logger"
+)
+
+// LIS v2 role vocabularies used in the id_token roles claim.
+const (
+	ContextPrefix     = "http://purl.imsglobal.org/vocab/lis/v2/membership#"
+	InstitutionPrefix = "http://purl.imsglobal.org/vocab/lis/v2/institution/person#"
+)
+
+// InstitutionPolicy controls how a user's institution roles combine with context roles.
+type InstitutionPolicy string
+
+const (
+	// InstitutionInclude emits institution roles alongside context roles.
+	InstitutionInclude InstitutionPolicy = "include"
+	// InstitutionFallback emits institution roles only when the user has no context membership.
+	InstitutionFallback InstitutionPolicy = "fallback"
+	// InstitutionNone never emits institution roles.
+	InstitutionNone InstitutionPolicy = "none"
+)
+
+// Mapper turns platform role names into LTI role URIs.
+// Full URIs pass through; short names (e.g. "Instructor") expand into the context or
+// institution vocabulary depending on where the role was assigned, unless an alias maps them.
+type Mapper struct {
+	aliases     map[string]string
+	institution InstitutionPolicy
+}
+
+// NewMapper builds a Mapper from alias entries (local name -> role URI or short LIS name).
+func NewMapper(aliases map[string]string, institution InstitutionPolicy) *Mapper {
+	m := &Mapper{aliases: map[string]string{}, institution: institution}
+	for k, v := range aliases {
+		m.aliases[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+	}
+	if m.institution == "" {
+		m.institution = InstitutionInclude
+	}
+	return m
+}
+
+// FromEnv reads LTI_ROLE_MAP ("Teacher=Instructor,TA=http://...#TeachingAssistant") and
+// LTI_INSTITUTION_ROLES (include|fallback|none, default include). Invalid entries are logged and skipped.
+func FromEnv() *Mapper {
+	aliases := map[string]string{}
+	for _, pair := range strings.Split(os.Getenv("LTI_ROLE_MAP"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" || strings.TrimSpace(v) == "" {
+			logger.Warn("ltiroles: ignoring invalid LTI_ROLE_MAP entry %q", pair)
+			continue
+		}
+		aliases[k] = v
+	}
+	policy := InstitutionPolicy(strings.ToLower(strings.TrimSpace(os.Getenv("LTI_INSTITUTION_ROLES"))))
+	switch policy {
+	case "", InstitutionInclude, InstitutionFallback, InstitutionNone:
+	default:
+		logger.Warn("ltiroles: unknown LTI_INSTITUTION_ROLES %q, using %s", policy, InstitutionInclude)
+		policy = InstitutionInclude
+	}
+	return NewMapper(aliases, policy)
+}
+
+// Resolve returns the roles claim for a launch. contextRoles come from the user's
+// roster membership (member reports whether one exists); institutionRoles from the user record.
+func (m *Mapper) Resolve(contextRoles, institutionRoles []string, member bool) []string {
+	out := []string{}
+	seen := map[string]bool{}
+	add := func(roles []string, prefix string) {
+		for _, r := range roles {
+			uri := m.mapRole(r, prefix)
+			if uri == "" || seen[uri] {
+				continue
+			}
+			seen[uri] = true
+			out = append(out, uri)
+		}
+	}
+	add(contextRoles, ContextPrefix)
+	switch m.institution {
+	case InstitutionInclude:
+		add(institutionRoles, InstitutionPrefix)
+	case InstitutionFallback:
+		if !member {
+			add(institutionRoles, InstitutionPrefix)
+		}
+	}
+	return out
+}
+
+func (m *Mapper) mapRole(role, prefix string) string {
+	role = strings.TrimSpace(role)
+	if role == "" {
+		return ""
+	}
+	if alias, ok := m.aliases[strings.ToLower(role)]; ok {
+		role = alias
+	}
+	if strings.Contains(role, "://") {
+		return role
+	}
+	return prefix + role
+}
@@ -0,0 +1,77 @@
+package ltivars
+
+import (
+	"strings"
+	"time"
+)
+
+// Substitution variables from the LTI 1.3 core specification (appendix C) that the platform can resolve.
+const (
+	UserID                      = "$User.id"
+	UserUsername                = "$User.username"
+	PersonNameFull              = "$Person.name.full"
+	PersonNameGiven             = "$Person.name.given"
+	PersonNameFamily            = "$Person.name.family"
+	PersonEmailPrimary          = "$Person.email.primary"
+	MembershipRole              = "$Membership.role"
+	ContextID                   = "$Context.id"
+	ContextLabel                = "$Context.label"
+	ContextTitle                = "$Context.title"
+	ContextType                 = "$Context.type"
+	ResourceLinkID              = "$ResourceLink.id"
+	ResourceLinkTitle           = "$ResourceLink.title"
+	ResourceLinkDescription     = "$ResourceLink.description"
+	ResourceLinkAvailableStart  = "$ResourceLink.available.startDateTime"
+	ResourceLinkAvailableEnd    = "$ResourceLink.available.endDateTime"
+	ResourceLinkSubmissionStart = "$ResourceLink.submission.startDateTime"
+	ResourceLinkSubmissionEnd   = "$ResourceLink.submission.endDateTime"
+)
+
+// Vars holds the values of substitution variables known for one launch.
+// Variables without a value are left unexpanded, as the specification requires.
+type Vars map[string]string
+
+// Set records a variable value; empty values are ignored.
+func (v Vars) Set(name, value string) {
+	if value != "" {
+		v[name] = value
+	}
+}
+
+// SetTime records a timestamp variable in ISO 8601 format; nil is ignored.
+func (v Vars) SetTime(name string, t *time.Time) {
+	if t != nil {
+		v[name] = t.UTC().Format(time.RFC3339)
+	}
+}
+
+// SetList records a multi-valued variable as a comma-separated list.
+func (v Vars) SetList(name string, values []string) {
+	v.Set(name, strings.Join(values, ","))
+}
+
+// Merge combines custom parameter sets; later sets override earlier ones.
+func Merge(sets ...map[string]string) map[string]string {
+	out := map[string]string{}
+	for _, s := range sets {
+		for k, val := range s {
+			out[k] = val
+		}
+	}
+	return out
+}
+
+// Expand returns custom with every value that names a known variable replaced by its value.
+// Only whole values are substituted (e.g. "$User.id", not "id-$User.id").
+func (v Vars) Expand(custom map[string]string) map[string]string {
+	out := make(map[string]string, len(custom))
+	for k, val := range custom {
+		if strings.HasPrefix(val, "$") {
+			if sub, ok := v[strings.TrimSpace(val)]; ok {
+				val = sub
+			}
+		}
+		out[k] = val
+	}
+	return out
+}
@@ -0,0 +1,32 @@
+package contexts
+
+import (
+	"context"
+	"time"
+)
+
+// Context is a course (or other grouping) that tools are launched from.
+// ID is the context_id sent to tools; Types are LIS context type URIs.
+type Context struct {
+	ID        string    `json:"id"`
+	Label     string    `json:"label,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	Types     []string  `json:"type,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Repository defines storage operations for contexts.
+type Repository interface {
+	// ListContexts returns all contexts ordered by ID.
+	ListContexts(ctx context.Context) ([]*Context, error)
+	// GetContext returns a context by ID, or nil when not found.
+	GetContext(ctx context.Context, id string) (*Context, error)
+	// CreateContext inserts a context; the ID must be unique.
+	CreateContext(ctx context.Context, c *Context) error
+	// UpdateContext replaces label, title and types. Returns false when no context has that ID.
+	UpdateContext(ctx context.Context, c *Context) (bool, error)
+	// DeleteContext deletes a context by ID.
+	DeleteContext(ctx context.Context, id string) error
+	Disconnect()
+}
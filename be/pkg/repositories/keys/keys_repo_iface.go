@@ -0,0 +1,34 @@
+package keys
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when a kid does not exist.
+var ErrNotFound = errors.New("key not found")
+
+// Key is a persisted platform signing key. PrivateKeyPEM holds a PKCS#8 PEM block
+// and must never be serialized to API responses.
+type Key struct {
+	Kid           string     `json:"kid"`
+	Alg           string     `json:"alg"`
+	PrivateKeyPEM string     `json:"-"`
+	CreatedAt     time.Time  `json:"created_at"`
+	RetiredAt     *time.Time `json:"retired_at,omitempty"` // no longer used for signing
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"` // no longer published in the JWKS
+}
+
+// KeyStore persists platform signing keys so they survive restarts.
+type KeyStore interface {
+	// ListKeys returns all stored keys, newest first.
+	ListKeys(ctx context.Context) ([]*Key, error)
+	// CreateKey stores a new key. Kid must be unique.
+	CreateKey(ctx context.Context, k *Key) error
+	// RetireKey stops a key from signing at retiredAt (kept if already set) and from
+	// being published at expiresAt. Returns ErrNotFound when the kid is unknown.
+	RetireKey(ctx context.Context, kid string, retiredAt, expiresAt time.Time) error
+	// Disconnect gracefully closes resources. Should be safe to call on shutdown.
+	Disconnect()
+}
@@ -7,24 +7,203 @@ import (
 
 // Tool represents an LTI tool registration record.
 type Tool struct {
-	ID              int64     `json:"id"`
-	Name            string    `json:"name"`
-	ClientID        string    `json:"client_id"`
-	AuthURL         string    `json:"auth_url"`
-	TargetLinkURL   string    `json:"target_link_url"`
-	TargetLaunchURL string    `json:"target_launch_url"`
-	KeySetURL       string    `json:"key_set_url"`
-	CreatedAt       time.Time `json:"created_at"`
+	ID              int64             `json:"id"`
+	Name            string            `json:"name"`
+	ClientID        string            `json:"client_id"`
+	AuthURL         string            `json:"auth_url"`
+	TargetLinkURL   string            `json:"target_link_url"`
+	TargetLaunchURL string            `json:"target_launch_url"`
+	KeySetURL       string            `json:"key_set_url"`
+	IDTokenAlg      string            `json:"id_token_signed_response_alg,omitempty"` // JWS alg the tool accepts for id_tokens; empty = platform default
+	RedirectURIs    []string          `json:"redirect_uris,omitempty"`                // exact-match allow-list for OIDC redirect_uri; empty = legacy host/path check
+	Custom          map[string]string `json:"custom,omitempty"`                       // custom parameters sent on every launch of the tool
+	AllowedScopes   []string          `json:"allowed_scopes"`                         // service scopes the tool may be granted; see ServiceScopes
+	CreatedAt       time.Time         `json:"created_at"`
+}
+
+// LTI Advantage service scopes.
+const (
+	ScopeLineItem                  = "https://purl.imsglobal.org/spec/lti-ags/scope/lineitem"
+	ScopeLineItemReadonly          = "https://purl.imsglobal.org/spec/lti-ags/scope/lineitem.readonly"
+	ScopeResultReadonly            = "https://purl.imsglobal.org/spec/lti-ags/scope/result.readonly"
+	ScopeScore                     = "https://purl.imsglobal.org/spec/lti-ags/scope/score"
+	ScopeContextMembershipReadonly = "https://purl.imsglobal.org/spec/lti-nrps/scope/contextmembership.readonly"
+)
+
+// ServiceScopes are the LTI Advantage service scopes this platform can grant.
+var ServiceScopes = []string{ScopeLineItem, ScopeLineItemReadonly, ScopeResultReadonly, ScopeScore, ScopeContextMembershipReadonly}
+
+// ReadonlyServiceScopes are the service scopes a tool gets without an explicit grant.
+// ScopeLineItem and ScopeScore change the gradebook and must be granted by an admin.
+var ReadonlyServiceScopes = []string{ScopeLineItemReadonly, ScopeResultReadonly, ScopeContextMembershipReadonly}
+
+// Deployment scopes. An institution deployment covers every context; a course
+// deployment covers only its ContextID.
+const (
+	DeploymentScopeInstitution = "institution"
+	DeploymentScopeCourse      = "course"
+)
+
+// Deployment is one placement of a tool; a tool may be deployed at several scopes.
+// DeploymentID is the value sent to the tool in the deployment_id claim.
+type Deployment struct {
+	ID           int64             `json:"id"`
+	ToolID       int64             `json:"tool_id"`
+	DeploymentID string            `json:"deployment_id"`
+	Label        string            `json:"label,omitempty"`
+	Scope        string            `json:"scope"`
+	ContextID    string            `json:"context_id,omitempty"`
+	Custom       map[string]string `json:"custom,omitempty"` // custom parameters for launches under this deployment
+	CreatedAt    time.Time         `json:"created_at"`
+}
+
+// Covers reports whether the deployment applies to launches and service calls in contextID.
+func (d *Deployment) Covers(contextID string) bool {
+	return d.Scope != DeploymentScopeCourse || d.ContextID == contextID
+}
+
+// ResourceLink is a placement of a tool's content in a context. ID is the value sent to the
+// tool as resource_link.id; links are created from deep linking content items or by hand.
+type ResourceLink struct {
+	ID           string            `json:"id"`
+	ContextID    string            `json:"context_id"`
+	ToolID       int64             `json:"tool_id"`
+	DeploymentID string            `json:"deployment_id"`
+	Title        string            `json:"title,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	URL          string            `json:"url,omitempty"`
+	Custom       map[string]string `json:"custom,omitempty"`
+	// Availability and submission windows from the deep linking content item ("available", "submission").
+	AvailableStart  *time.Time `json:"available_start,omitempty"`
+	AvailableEnd    *time.Time `json:"available_end,omitempty"`
+	SubmissionStart *time.Time `json:"submission_start,omitempty"`
+	SubmissionEnd   *time.Time `json:"submission_end,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// Deep Linking content item types.
+const (
+	ContentItemLink            = "link"
+	ContentItemFile            = "file"
+	ContentItemHTML            = "html"
+	ContentItemImage           = "image"
+	ContentItemLtiResourceLink = "ltiResourceLink"
+)
+
+// ContentItemTypes lists the content item types the platform accepts, in the order advertised.
+var ContentItemTypes = []string{ContentItemLtiResourceLink, ContentItemLink, ContentItemFile, ContentItemHTML, ContentItemImage}
+
+// ContentItem is a deep linking content item in typed form, using the specification's field names.
+// Which fields apply depends on Type.
+type ContentItem struct {
+	Type      string        `json:"type"`
+	Title     string        `json:"title,omitempty"`
+	Text      string        `json:"text,omitempty"`
+	URL       string        `json:"url,omitempty"`       // all but html
+	Icon      *ContentImage `json:"icon,omitempty"`      // all but html
+	Thumbnail *ContentImage `json:"thumbnail,omitempty"` // all but html
+
+	Embed  *ContentEmbed  `json:"embed,omitempty"`  // link
+	Window *ContentWindow `json:"window,omitempty"` // link, ltiResourceLink
+	Iframe *ContentIframe `json:"iframe,omitempty"` // link, ltiResourceLink
+
+	HTML string `json:"html,omitempty"` // html, sanitized
+
+	Width  int `json:"width,omitempty"`  // image
+	Height int `json:"height,omitempty"` // image
+
+	MediaType string     `json:"mediaType,omitempty"` // file
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"` // file
+
+	Custom     map[string]string `json:"custom,omitempty"`     // ltiResourceLink
+	Available  *TimeWindow       `json:"available,omitempty"`  // ltiResourceLink
+	Submission *TimeWindow       `json:"submission,omitempty"` // ltiResourceLink
+}
+
+// ContentImage is an icon or thumbnail of a content item.
+type ContentImage struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// ContentEmbed is HTML the platform may embed for a link, sanitized.
+type ContentEmbed struct {
+	HTML string `json:"html"`
+}
+
+// ContentWindow asks the platform to open the item in a new window.
+type ContentWindow struct {
+	TargetName     string `json:"targetName,omitempty"`
+	Width          int    `json:"width,omitempty"`
+	Height         int    `json:"height,omitempty"`
+	WindowFeatures string `json:"windowFeatures,omitempty"`
+}
+
+// ContentIframe asks the platform to show the item in an iframe.
+type ContentIframe struct {
+	Src    string `json:"src,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// TimeWindow is an availability or submission window of a resource link.
+type TimeWindow struct {
+	StartDateTime *time.Time `json:"startDateTime,omitempty"`
+	EndDateTime   *time.Time `json:"endDateTime,omitempty"`
+}
+
+// Deep linking session states.
+const (
+	DeepLinkSessionOpen      = "open"      // request issued, waiting for the tool's response
+	DeepLinkSessionCompleted = "completed" // the tool returned content items
+	DeepLinkSessionCancelled = "cancelled" // the tool returned no items and no error
+	DeepLinkSessionFailed    = "failed"    // the tool reported an error (errormsg / errorlog)
+)
+
+// DeepLinkSession is one deep linking exchange: opened when the platform signs an
+// LtiDeepLinkingRequest and closed by the tool's response. It keeps what was requested,
+// for whom and where, and the messages the tool sent back.
+type DeepLinkSession struct {
+	ID           string `json:"id"`
+	ClientID     string `json:"client_id"`
+	DeploymentID string `json:"deployment_id,omitempty"`
+	ContextID    string `json:"context_id,omitempty"`
+	UserID       string `json:"user_id,omitempty"`
+
+	AcceptTypes      []string `json:"accept_types"`
+	AcceptMultiple   bool     `json:"accept_multiple"`
+	AcceptMediaTypes string   `json:"accept_media_types,omitempty"`
+	AcceptLineItem   bool     `json:"accept_lineitem"`
+
+	Status    string `json:"status"`
+	ItemCount int    `json:"item_count"`
+	Msg       string `json:"msg,omitempty"`
+	Log       string `json:"log,omitempty"`
+	ErrorMsg  string `json:"errormsg,omitempty"`
+	ErrorLog  string `json:"errorlog,omitempty"`
+
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty"`
 }
 
 // DeepLinkSelection represents a persisted deep-link content item for reuse.
 type DeepLinkSelection struct {
-	ID              int64     `json:"id"`
-	ClientID        string    `json:"client_id"`
-	ToolName        string    `json:"tool_name"`
-	URL             string    `json:"url"`
-	ContentItemJSON string    `json:"content_item_json"`
-	CreatedAt       time.Time `json:"created_at"`
+	ID              int64        `json:"id"`
+	ClientID        string       `json:"client_id"`
+	ToolName        string       `json:"tool_name"`
+	Type            string       `json:"type,omitempty"`
+	URL             string       `json:"url"`
+	Item            *ContentItem `json:"item,omitempty"` // typed content item; nil when it could not be parsed
+	ContentItemJSON string       `json:"content_item_json"`
+	ResourceLinkID  string       `json:"resource_link_id,omitempty"` // resource link created from this item, if any
+	SessionID       string       `json:"session_id,omitempty"`       // deep linking session the item was returned in
+	ContextID       string       `json:"context_id,omitempty"`
+	DeploymentID    string       `json:"deployment_id,omitempty"`
+	UserID          string       `json:"user_id,omitempty"` // user who picked the item
+	CreatedAt       time.Time    `json:"created_at"`
 }
 
 // Repository defines storage operations for LTI data.
@@ -35,18 +214,62 @@ type Repository interface {
 	Disconnect()
 	// RegisterTool inserts a new tool registration and returns its ID.
 	RegisterTool(ctx context.Context, t *Tool) (int64, error)
+	// RegisterToolWithDeployment inserts t and its deployment d (ToolID is set from t) in a
+	// single transaction.
+	RegisterToolWithDeployment(ctx context.Context, t *Tool, d *Deployment) error
 	// ListTools returns all registered tools.
 	ListTools(ctx context.Context) ([]*Tool, error)
 	// GetToolByClientID returns a tool by its client_id.
 	GetToolByClientID(ctx context.Context, clientID string) (*Tool, error)
 	// GetToolByID returns a tool by its ID.
 	GetToolByID(ctx context.Context, id int64) (*Tool, error)
-	// DeleteToolByID deletes a tool by its numeric ID.
+	// ListToolsWithoutAllowedScopes returns the tools registered before scopes were restricted
+	// whose allowed scopes have not been set yet.
+	ListToolsWithoutAllowedScopes(ctx context.Context) ([]*Tool, error)
+	// UpdateToolAllowedScopes replaces the service scopes a tool may be granted.
+	// Returns false if the tool does not exist.
+	UpdateToolAllowedScopes(ctx context.Context, id int64, scopes []string) (bool, error)
+	// DeleteToolByID deletes a tool by its numeric ID, with its deployments and resource links.
 	DeleteToolByID(ctx context.Context, id int64) error
 
+	// Deployments of a tool
+	CreateDeployment(ctx context.Context, d *Deployment) (int64, error)
+	// ListDeployments returns all deployments of a tool.
+	ListDeployments(ctx context.Context, toolID int64) ([]*Deployment, error)
+	// GetDeployment returns a tool's deployment by deployment_id, or nil when not found.
+	GetDeployment(ctx context.Context, toolID int64, deploymentID string) (*Deployment, error)
+	DeleteDeployment(ctx context.Context, toolID int64, deploymentID string) error
+
+	// Resource links
+	// CreateResourceLink stores a resource link, generating its ID when empty.
+	CreateResourceLink(ctx context.Context, l *ResourceLink) error
+	// ListResourceLinks returns resource links, filtered by context when contextID is non-empty.
+	ListResourceLinks(ctx context.Context, contextID string) ([]*ResourceLink, error)
+	// GetResourceLink returns a resource link by ID, or nil when not found.
+	GetResourceLink(ctx context.Context, id string) (*ResourceLink, error)
+	// UpdateResourceLink replaces title, description, url, custom and the availability windows; false when not found.
+	UpdateResourceLink(ctx context.Context, l *ResourceLink) (bool, error)
+	DeleteResourceLink(ctx context.Context, id string) error
+
 	// Persisted deep link selections
 	CreateDeepLinkSelection(ctx context.Context, sel *DeepLinkSelection) (int64, error)
+	// CreateDeepLinkSelectionWithLink stores link (when non-nil, generating its ID when empty) and
+	// sel, with sel.ResourceLinkID set to the link, in a single transaction.
+	CreateDeepLinkSelectionWithLink(ctx context.Context, sel *DeepLinkSelection, link *ResourceLink) error
 	ListDeepLinkSelections(ctx context.Context) ([]*DeepLinkSelection, error)
 	GetDeepLinkSelection(ctx context.Context, id int64) (*DeepLinkSelection, error)
 	DeleteDeepLinkSelection(ctx context.Context, id int64) error
+
+	// Deep linking sessions
+	// CreateDeepLinkSession stores a session, generating its ID when empty.
+	CreateDeepLinkSession(ctx context.Context, s *DeepLinkSession) error
+	// CloseDeepLinkSession records the response outcome (status and tool messages) on an open,
+	// unexpired session; false when the session is unknown, already closed or expired.
+	CloseDeepLinkSession(ctx context.Context, s *DeepLinkSession) (bool, error)
+	// SetDeepLinkSessionItemCount records how many content items were kept from the response.
+	SetDeepLinkSessionItemCount(ctx context.Context, id string, n int) error
+	// ListDeepLinkSessions returns sessions newest first, filtered by context when contextID is non-empty.
+	ListDeepLinkSessions(ctx context.Context, contextID string) ([]*DeepLinkSession, error)
+	// GetDeepLinkSession returns a session by ID, or nil when not found.
+	GetDeepLinkSession(ctx context.Context, id string) (*DeepLinkSession, error)
 }
@@ -23,6 +23,8 @@ type Repository interface {
     // ListMembersPage returns members for a context with pagination,
     // along with the total count for the context.
     ListMembersPage(ctx context.Context, contextID string, offset, limit int) ([]*Member, int, error)
+    // GetMember returns a single membership, or nil when the user is not in the context.
+    GetMember(ctx context.Context, contextID, userID string) (*Member, error)
     UpsertMember(ctx context.Context, contextID string, m *Member) error
     DeleteMember(ctx context.Context, contextID, userID string) error
     Disconnect()
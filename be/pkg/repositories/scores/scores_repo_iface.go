@@ -2,13 +2,22 @@ package scores
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
+var (
+	// ErrStaleScore is returned when a score's timestamp is older than the user's current result.
+	ErrStaleScore = errors.New("score timestamp is older than the current result")
+	// ErrDuplicateScoreTimestamp is returned when a score's timestamp equals the current result's.
+	ErrDuplicateScoreTimestamp = errors.New("score timestamp equals the current result")
+)
+
 // LineItem represents an AGS line item within a specific context (e.g., course).
 type LineItem struct {
 	ID             int64      `json:"id"`
 	ContextID      string     `json:"-"` // context conveyed via URL path; not part of payload
+	ClientID       string     `json:"-"` // tool that created the line item; empty for line items stored before owners were recorded
 	Label          string     `json:"label"`
 	ResourceID     string     `json:"resourceId,omitempty"`
 	ResourceLinkID string     `json:"resourceLinkId,omitempty"`
@@ -16,10 +25,39 @@ type LineItem struct {
 	ScoreMaximum   float64    `json:"scoreMaximum"`
 	StartAt        *time.Time `json:"startDateTime,omitempty"`
 	EndAt          *time.Time `json:"endDateTime,omitempty"`
+	GradesReleased *bool      `json:"gradesReleased,omitempty"`
 	CreatedAt      time.Time  `json:"-"`
 	UpdatedAt      time.Time  `json:"-"`
 }
 
+// LineItemFilter narrows a line item listing; empty fields do not filter.
+type LineItemFilter struct {
+	ResourceLinkID string
+	ResourceID     string
+	Tag            string
+	// OwnerClientID keeps only line items created by that tool or linked to one of OwnerResourceLinkIDs.
+	OwnerClientID        string
+	OwnerResourceLinkIDs []string
+}
+
+// Score activityProgress values per AGS spec.
+const (
+	ActivityInitialized = "Initialized"
+	ActivityStarted     = "Started"
+	ActivityInProgress  = "InProgress"
+	ActivitySubmitted   = "Submitted"
+	ActivityCompleted   = "Completed"
+)
+
+// Score gradingProgress values per AGS spec. Only FullyGraded makes a result final.
+const (
+	GradingFullyGraded   = "FullyGraded"
+	GradingPending       = "Pending"
+	GradingPendingManual = "PendingManual"
+	GradingFailed        = "Failed"
+	GradingNotReady      = "NotReady"
+)
+
 // Score is the POST payload to record a user's score. This is used to upsert a Result.
 type Score struct {
 	UserID       string    `json:"userId"`
@@ -32,8 +70,19 @@ type Score struct {
 	GradingProgress  string `json:"gradingProgress,omitempty"`
 }
 
+// ScoreRecord is one score as it was received, kept in the append-only score history.
+type ScoreRecord struct {
+	ID         int64  `json:"id"`
+	LineItemID int64  `json:"lineItemId"`
+	ClientID   string `json:"clientId"` // tool that submitted the score
+	Score
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
 // Result represents the latest computed result per user for a line item.
 type Result struct {
+	ID               int64     `json:"-"` // exposed as a URL by the AGS handler
+	LineItemID       int64     `json:"-"`
 	UserID           string    `json:"userId"`
 	ResultScore      *float64  `json:"resultScore,omitempty"`
 	ResultMaximum    *float64  `json:"resultMaximum,omitempty"`
@@ -46,13 +95,32 @@ type Result struct {
 // Repository defines persistence for AGS entities.
 type Repository interface {
 	CreateLineItem(ctx context.Context, li *LineItem) (int64, error)
-	ListLineItems(ctx context.Context, contextID string) ([]*LineItem, error)
+	// CreateLineItemWithMapping creates li and maps it to li.ResourceLinkID in a single transaction,
+	// so a line item is never left without its mapping.
+	CreateLineItemWithMapping(ctx context.Context, li *LineItem) (int64, error)
+	// ListLineItemsPage returns one page (by offset/limit, ordered by id) of the context's line items
+	// matching f, and the total number of matching line items.
+	ListLineItemsPage(ctx context.Context, contextID string, f LineItemFilter, offset, limit int) ([]*LineItem, int, error)
 	GetLineItem(ctx context.Context, id int64, contextID string) (*LineItem, error)
 	UpdateLineItem(ctx context.Context, li *LineItem) error
+	// DeleteLineItem deletes the line item and its resource link mapping.
 	DeleteLineItem(ctx context.Context, id int64, contextID string) error
 
-	UpsertResultFromScore(ctx context.Context, lineItemID int64, contextID string, s *Score) error
-	ListResultsByLineItem(ctx context.Context, lineItemID int64, contextID string) ([]*Result, error)
+	// RecordScore appends s as received, submitted by clientID, to the score history and derives
+	// the user's result from the latest history row, in a single transaction. A result from a
+	// score without ScoreGiven has no ResultMaximum. A score older than the current result returns
+	// ErrStaleScore and one with the same timestamp ErrDuplicateScoreTimestamp; neither is stored.
+	RecordScore(ctx context.Context, lineItemID int64, contextID, clientID string, s *Score) error
+	// ListScoreHistory returns one page (by offset/limit, oldest first) of the scores received for
+	// userID on the line item, and the total number of them.
+	ListScoreHistory(ctx context.Context, lineItemID int64, contextID, userID string, offset, limit int) ([]*ScoreRecord, int, error)
+	// HasClientScores reports whether any score was received from clientID.
+	HasClientScores(ctx context.Context, clientID string) (bool, error)
+	// ListResultsPage returns one page (by offset/limit, ordered by user_id) of the line item's results,
+	// only userID's when userID is non-empty, and the total number of matching results.
+	ListResultsPage(ctx context.Context, lineItemID int64, contextID, userID string, offset, limit int) ([]*Result, int, error)
+	// GetResult returns result id of the line item, or nil if it does not exist.
+	GetResult(ctx context.Context, id, lineItemID int64, contextID string) (*Result, error)
 
 	// CreateLineItemMapping creates a one-to-one mapping between a lineItemId and a resourceLinkId.
 	// Both lineItemId and resourceLinkId must be globally unique across the mapping table.
@@ -0,0 +1,53 @@
+package users
+
+import (
+	"context"
+	"time"
+)
+
+// User is a platform account that can launch tools.
+// ID is the stable, opaque identifier sent to tools as `sub` and `login_hint`.
+// Roles are institution-level LIS role URIs; context roles come from the roster.
+type User struct {
+	ID         string    `json:"id"`
+	Email      string    `json:"email"`
+	Name       string    `json:"name,omitempty"`
+	GivenName  string    `json:"given_name,omitempty"`
+	FamilyName string    `json:"family_name,omitempty"`
+	Roles      []string  `json:"roles,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// FullName returns Name, or given and family name joined when Name is empty.
+func (u *User) FullName() string {
+	if u.Name != "" {
+		return u.Name
+	}
+	switch {
+	case u.GivenName != "" && u.FamilyName != "":
+		return u.GivenName + " " + u.FamilyName
+	case u.GivenName != "":
+		return u.GivenName
+	default:
+		return u.FamilyName
+	}
+}
+
+// Repository defines storage operations for platform users.
+type Repository interface {
+	// ListUsers returns all users ordered by email.
+	ListUsers(ctx context.Context) ([]*User, error)
+	// GetUserByID returns a user by ID, or nil when not found.
+	GetUserByID(ctx context.Context, id string) (*User, error)
+	// GetUserByEmail returns a user by email (case-insensitive), or nil when not found.
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	// CreateUser inserts a user; an empty ID is generated.
+	CreateUser(ctx context.Context, u *User) error
+	// UpdateUser replaces the profile and roles of an existing user.
+	// Returns false when no user has that ID.
+	UpdateUser(ctx context.Context, u *User) (bool, error)
+	// DeleteUser deletes a user by ID.
+	DeleteUser(ctx context.Context, id string) error
+	Disconnect()
+}
@@ -5,18 +5,70 @@ import (
     "time"
 )
 
+// OIDCState is the launch data bound to an OIDC state between launchStart and oidcAuth.
+type OIDCState struct {
+    ClientID       string
+    TargetLinkURI  string
+    ContextID      string // used later in OIDC to populate AGS claims
+    ResourceLinkID string // identifies the content item
+    UserID         string // platform user the launch was started for
+    DeploymentID   string // tool deployment the launch runs under
+    DeepLinking    *DeepLinkSettings // deep linking settings requested by the launch; nil means defaults
+}
+
+// DeepLinkSettings are the deep_linking_settings a launch asks the tool to honour.
+// Unset fields fall back to the platform defaults when the request is issued.
+type DeepLinkSettings struct {
+    AcceptTypes                       []string `json:"accept_types,omitempty"`
+    AcceptPresentationDocumentTargets []string `json:"accept_presentation_document_targets,omitempty"`
+    AcceptMediaTypes                  string   `json:"accept_media_types,omitempty"` // comma-separated MIME types for file items
+    AcceptMultiple                    bool     `json:"accept_multiple,omitempty"`
+    AcceptLineItem                    *bool    `json:"accept_lineitem,omitempty"` // nil means true
+    AutoCreate                        bool     `json:"auto_create,omitempty"`
+    Title                             string   `json:"title,omitempty"`
+    Text                              string   `json:"text,omitempty"`
+}
+
+// IssuedToken records an access token issued by the OAuth2 token endpoint, keyed by its jti.
+type IssuedToken struct {
+    JTI          string     `json:"jti"`
+    ClientID     string     `json:"client_id"`
+    Scope        string     `json:"scope"`
+    DeploymentID string     `json:"deployment_id,omitempty"`
+    IssuedAt     time.Time  `json:"issued_at"`
+    ExpiresAt    time.Time  `json:"expires_at"`
+    RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+}
+
 // Repository defines storage needed for security validation concerns such as
-// client_assertion JTI replay protection and OIDC state/nonce persistence.
+// client_assertion JTI replay protection, issued access tokens and OIDC state/nonce persistence.
 type Repository interface {
     // TryUseClientAssertionJTI attempts to record a client_assertion jti for replay protection.
     // It should return true if the jti was newly recorded, false if it already existed (replay),
     // and an error for storage issues.
     TryUseClientAssertionJTI(ctx context.Context, jti string, clientID string, exp time.Time) (bool, error)
 
-    // CreateOIDCState stores an OIDC state with optional metadata and expiry.
-    // contextID is used later in OIDC to populate AGS claims; resourceLinkID identifies the content item.
-    CreateOIDCState(ctx context.Context, state string, clientID string, targetLinkURI string, contextID string, resourceLinkID string, exp time.Time) error
+    // CreateOIDCState stores an OIDC state with its launch data and expiry.
+    CreateOIDCState(ctx context.Context, state string, data *OIDCState, exp time.Time) error
     // ConsumeOIDCState atomically loads and invalidates a state, returning its data.
     // ok=false if not found or already used/expired.
-    ConsumeOIDCState(ctx context.Context, state string) (clientID string, targetLinkURI string, resourceLinkID string, contextID string, ok bool, err error)
+    ConsumeOIDCState(ctx context.Context, state string) (data *OIDCState, ok bool, err error)
+
+    // RecordIssuedToken stores an access token issued to a tool.
+    RecordIssuedToken(ctx context.Context, t *IssuedToken) error
+    // GetIssuedToken returns the issued access token with jti, or nil if it is unknown.
+    GetIssuedToken(ctx context.Context, jti string) (*IssuedToken, error)
+    // RevokeIssuedToken revokes the access token with jti.
+    // ok=false if it is unknown or was already revoked.
+    RevokeIssuedToken(ctx context.Context, jti string) (ok bool, err error)
+    // RevokeClientTokens revokes every unexpired access token of clientID and returns how many were revoked.
+    RevokeClientTokens(ctx context.Context, clientID string) (int64, error)
+    // ListClientTokenScopes returns the distinct scopes of the recorded access tokens of clientID.
+    ListClientTokenScopes(ctx context.Context, clientID string) ([]string, error)
+
+    // CreateRegistrationToken stores a one-time LTI Dynamic Registration token with expiry.
+    CreateRegistrationToken(ctx context.Context, token string, exp time.Time) error
+    // ConsumeRegistrationToken atomically validates and invalidates a registration token.
+    // ok=false if not found or already used/expired.
+    ConsumeRegistrationToken(ctx context.Context, token string) (ok bool, err error)
 }
@@ -162,7 +162,7 @@ export function ToolLaunch() {
                         <input type="hidden" name="login_initiation_url" value={toolFor?.auth_url || ''} />
                         {/* Send the content URL as target_link_uri for backend to forward to tool launch */}
                         <input type="hidden" name="target_link_uri" value={targetContentUrl} />
-                        <input type="hidden" name="resource_link_id" value={s.id} />
+                        <input type="hidden" name="resource_link_id" value={s.resource_link_id || ''} />
                         {/* Use configured email for login_hint and include context_id */}
                         <input type="hidden" name="login_hint" value={email} />
                         <input type="hidden" name="context_id" value={contextId} />
@@ -174,7 +174,7 @@ export function ToolLaunch() {
                         <div>client_id: {toolFor?.client_id || '(none)'}</div>
                         <div>launch_url: {launchUrl || '(none)'}</div>
                         <div>target_link_uri: {targetContentUrl || '(none)'}</div>
-                        <div>resource_link_id: {s.id || '(none)'}
+                        <div>resource_link_id: {s.resource_link_id || '(none)'}
                         </div>
                         <div>email (login_hint): {email || '(none)'}
                         </div>